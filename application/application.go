@@ -6,17 +6,59 @@ type Flags struct {
 	ListAllInterfaces bool
 	Port              int
 	Path              string
+	PathLength        int
+	PathAlphabet      string
 	Interface         string
 	Bind              string
 	FQDN              string
 	Zip               bool
+	Archive           string
+	CompressionLevel  *int
+	Exclude           []string
+	IncludeHidden     bool
+	RespectGitignore  bool
+	Name              string
 	Config            string
 	Browser           bool
 	Secure            bool
 	TlsCert           string
 	TlsKey            string
+	TlsCache          bool
+	TlsMinVersion     string
+	TlsCiphers        string
+	HTTP2             *bool
+	Pin               string
 	Output            string
+	Stdout            bool
+	OutputFormat      string
 	Reversed          bool
+	OnReceive         string
+	OnSendComplete    string
+	// AbortOnHookFailure stops the server when a hook fails, even if it is
+	// kept alive
+	AbortOnHookFailure bool
+	WebhookURL         string
+	WebhookSecret      string
+	LogLevel           string
+	LogFormat          string
+	LogFile            string
+	MetricsAddr        string
+	// Approve asks on the terminal whether to accept every new client
+	Approve        bool
+	Allow          []string
+	Deny           []string
+	SameSubnetOnly bool
+	LimitRate      string
+	// LimitRatePerConnection is the limit of every connection, under the
+	// global LimitRate
+	LimitRatePerConnection string
+}
+
+// ReservesStdout reports whether the standard output is kept for the
+// received content or for JSON events, messages are printed to the standard
+// error in that case
+func (f Flags) ReservesStdout() bool {
+	return f.Stdout || f.OutputFormat == "json"
 }
 
 type App struct {
@@ -0,0 +1,207 @@
+// Package archive writes files and directories to an archive while it is
+// being read, so that it never needs to be stored on disk
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// modTime is the modification time of all the entries, so that archiving
+// the same content twice gives the same archive. It is the earliest time zip
+// files can store
+var modTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// format describes the files of an archive format
+type format struct {
+	extension   string
+	contentType string
+}
+
+// formats are the supported archive formats
+var formats = map[string]format{
+	"zip":  {".zip", "application/zip"},
+	"tar":  {".tar", "application/x-tar"},
+	"tgz":  {".tar.gz", "application/gzip"},
+	"tzst": {".tar.zst", "application/zstd"},
+}
+
+// Options of an archive
+type Options struct {
+	// Format is zip, tar, tgz or tzst. It is zip if empty
+	Format string
+	// Level of compression, from 0 to 9. With 0 files are stored as they
+	// are, which is best for media that is already compressed, except for
+	// tzst: zstd always compresses, so its levels start at 1. The default
+	// of the format is used if nil
+	Level *int
+	// Exclude files matching these patterns, which have the syntax of
+	// .gitignore files and are relative to the directories being archived
+	Exclude []string
+	// IncludeHidden archives the files and directories whose name starts
+	// with a dot found in directories, which are skipped otherwise
+	IncludeHidden bool
+	// RespectGitignore skips the files ignored by the .gitignore and
+	// .qrcpignore files found in directories
+	RespectGitignore bool
+}
+
+// Validate checks that the options are supported
+func (o Options) Validate() error {
+	if _, ok := formats[o.format()]; !ok {
+		names := make([]string, 0, len(formats))
+		for name := range formats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unsupported archive format %q, use one of: %s", o.Format, strings.Join(names, ", "))
+	}
+	if o.Level != nil && (*o.Level < 0 || *o.Level > 9) {
+		return fmt.Errorf("invalid compression level %d, use a value from 0 to 9", *o.Level)
+	}
+	if o.Level != nil && *o.Level == 0 && o.format() == "tzst" {
+		return fmt.Errorf("zstd can't store files without compression, use the tar format or a level from 1 to 9")
+	}
+	return nil
+}
+
+// Extension of the archive files, including the leading dot
+func (o Options) Extension() string {
+	return formats[o.format()].extension
+}
+
+// ContentType is the media type of the archive
+func (o Options) ContentType() string {
+	return formats[o.format()].contentType
+}
+
+func (o Options) format() string {
+	if o.Format == "" {
+		return "zip"
+	}
+	return o.Format
+}
+
+// Write writes the files and directories at paths to w, as an archive
+func Write(w io.Writer, paths []string, opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	switch opts.format() {
+	case "tar":
+		return Tar(w, paths, opts)
+	case "tgz":
+		level := gzip.DefaultCompression
+		if opts.Level != nil {
+			level = *opts.Level
+		}
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return err
+		}
+		if err := Tar(gw, paths, opts); err != nil {
+			return err
+		}
+		return gw.Close()
+	case "tzst":
+		level := zstd.SpeedDefault
+		if opts.Level != nil {
+			level = zstd.EncoderLevelFromZstd(*opts.Level)
+		}
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+		if err != nil {
+			return err
+		}
+		if err := Tar(zw, paths, opts); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	default:
+		return Zip(w, paths, opts)
+	}
+}
+
+// walkFunc is called for every file, directory and symbolic link to add to
+// an archive. name is the slash-separated name of the entry in the archive,
+// file is its path on disk and info describes the entry itself, not the
+// target of a link
+type walkFunc func(name, file string, info fs.FileInfo) error
+
+// walk calls fn for root and, if it is a directory, for all of its content
+// that is not filtered out by opts, in lexical order. Entries are named after
+// their path relative to the parent of root. Other kinds of files, like
+// devices and sockets, are skipped
+func walk(root string, opts Options, fn walkFunc) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	// root is followed if it is a link, but keeps its name
+	target, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	base := filepath.Base(root)
+	var exclude []ignoreRule
+	for _, pattern := range opts.Exclude {
+		if rule, ok := parseIgnoreRule(pattern, ""); ok {
+			exclude = append(exclude, rule)
+		}
+	}
+	// Rules of the ignore files found so far. Those of a directory only
+	// apply to its content, so they can be kept in a single list
+	var ignore []ignoreRule
+	return filepath.WalkDir(target, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(target, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			// root is archived even if hidden, unless it is excluded
+			if ignored(exclude, base, entry.IsDir()) {
+				return filepath.SkipDir
+			}
+		} else if (!opts.IncludeHidden && strings.HasPrefix(entry.Name(), ".")) ||
+			(opts.RespectGitignore && entry.IsDir() && entry.Name() == ".git") ||
+			ignored(exclude, rel, entry.IsDir()) ||
+			ignored(ignore, rel, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		if info.IsDir() && opts.RespectGitignore {
+			dir := rel
+			if dir == "." {
+				dir = ""
+			}
+			rules, err := readIgnoreFiles(file, dir)
+			if err != nil {
+				return err
+			}
+			ignore = append(ignore, rules...)
+		}
+		return fn(path.Join(base, rel), file, info)
+	})
+}
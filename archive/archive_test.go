@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testFiles creates a folder with some content, and a single file
+func testFiles(t *testing.T) []string {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "folder", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"folder/a.txt":     "a",
+		"folder/sub/b.txt": "b",
+		"single.txt":       "single",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(dir, "folder", "link")); err != nil {
+		t.Fatal(err)
+	}
+	return []string{filepath.Join(dir, "folder"), filepath.Join(dir, "single.txt")}
+}
+
+func TestZip(t *testing.T) {
+	stored := 0
+	for _, level := range []*int{nil, &stored} {
+		var buf bytes.Buffer
+		if err := Write(&buf, testFiles(t), Options{Level: level}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("invalid archive: %v", err)
+		}
+		var names []string
+		for _, f := range r.File {
+			names = append(names, f.Name)
+			if level != nil && f.Method != zip.Store {
+				t.Errorf("%s method = %d, want %d", f.Name, f.Method, zip.Store)
+			}
+			if f.Name == "folder/link" {
+				rc, _ := f.Open()
+				content, _ := io.ReadAll(rc)
+				rc.Close()
+				if string(content) != "a" {
+					t.Errorf("folder/link = %q, want the content of its target", content)
+				}
+			}
+		}
+		want := []string{"folder/", "folder/a.txt", "folder/link", "folder/sub/", "folder/sub/b.txt", "single.txt"}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("entries = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestTar(t *testing.T) {
+	decompress := map[string]func(io.Reader) (io.Reader, error){
+		"tar": func(r io.Reader) (io.Reader, error) { return r, nil },
+		"tgz": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"tzst": func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			return d, err
+		},
+	}
+	for format, reader := range decompress {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, testFiles(t), Options{Format: format}); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			r, err := reader(&buf)
+			if err != nil {
+				t.Fatalf("invalid archive: %v", err)
+			}
+			tr := tar.NewReader(r)
+			var names []string
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("invalid archive: %v", err)
+				}
+				names = append(names, header.Name)
+				if header.Name == "folder/link" && (header.Typeflag != tar.TypeSymlink || header.Linkname != "a.txt") {
+					t.Errorf("folder/link is not a link to a.txt")
+				}
+				if header.Name == "folder/a.txt" && header.Mode&0777 != 0644 {
+					t.Errorf("folder/a.txt mode = %o, want 644", header.Mode&0777)
+				}
+			}
+			want := []string{"folder/", "folder/a.txt", "folder/link", "folder/sub/", "folder/sub/b.txt", "single.txt"}
+			if !reflect.DeepEqual(names, want) {
+				t.Errorf("entries = %v, want %v", names, want)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	invalid, store := 10, 0
+	tests := []struct {
+		opts      Options
+		extension string
+		wantErr   bool
+	}{
+		{Options{}, ".zip", false},
+		{Options{Format: "tgz"}, ".tar.gz", false},
+		{Options{Format: "tzst"}, ".tar.zst", false},
+		{Options{Format: "rar"}, "", true},
+		{Options{Level: &invalid}, ".zip", true},
+		{Options{Format: "tgz", Level: &store}, ".tar.gz", false},
+		{Options{Format: "tzst", Level: &store}, ".tar.zst", true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v Validate() error = %v, wantErr %v", tt.opts, err, tt.wantErr)
+		}
+		if got := tt.opts.Extension(); got != tt.extension {
+			t.Errorf("%+v Extension() = %q, want %q", tt.opts, got, tt.extension)
+		}
+	}
+}
+
+// tarNames returns the names of the entries of a tar archive of paths
+func tarNames(t *testing.T, paths []string, opts Options) []string {
+	var buf bytes.Buffer
+	opts.Format = "tar"
+	if err := Write(&buf, paths, opts); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatalf("invalid archive: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"project/.git/HEAD":         "ref",
+		"project/.env":              "secret",
+		"project/.gitignore":        "*.log\nbuild/\n!keep.log\n",
+		"project/main.go":           "package main",
+		"project/debug.log":         "debug",
+		"project/keep.log":          "keep",
+		"project/build/out":         "binary",
+		"project/docs/.qrcpignore":  "draft.md\n",
+		"project/docs/draft.md":     "draft",
+		"project/docs/index.md":     "index",
+		"project/node_modules/x.js": "x",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths := []string{filepath.Join(dir, "project")}
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"default", Options{}, []string{
+			"project/", "project/build/", "project/build/out", "project/debug.log", "project/docs/",
+			"project/docs/draft.md", "project/docs/index.md", "project/keep.log", "project/main.go",
+			"project/node_modules/", "project/node_modules/x.js",
+		}},
+		{"exclude", Options{Exclude: []string{"node_modules/", "*.log", "docs/*.md"}}, []string{
+			"project/", "project/build/", "project/build/out", "project/docs/", "project/main.go",
+		}},
+		{"gitignore", Options{RespectGitignore: true, Exclude: []string{"node_modules"}}, []string{
+			"project/", "project/docs/", "project/docs/index.md", "project/keep.log", "project/main.go",
+		}},
+		{"hidden", Options{IncludeHidden: true, RespectGitignore: true, Exclude: []string{"/docs", "node_modules"}}, []string{
+			"project/", "project/.env", "project/.gitignore", "project/keep.log", "project/main.go",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tarNames(t, paths, tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	paths := testFiles(t)
+	for _, format := range []string{"zip", "tgz"} {
+		var first, second bytes.Buffer
+		if err := Write(&first, paths, Options{Format: format}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		// Touching the files doesn't change the archive
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(filepath.Join(paths[0], "a.txt"), later, later); err != nil {
+			t.Fatal(err)
+		}
+		if err := Write(&second, paths, Options{Format: format}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("%s archives of the same content differ", format)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are read in every directory when Options.RespectGitignore is
+// set. Rules of .qrcpignore come last, so they take precedence
+var ignoreFiles = []string{".gitignore", ".qrcpignore"}
+
+// ignoreRule is a pattern with the syntax of .gitignore files
+type ignoreRule struct {
+	// base is the directory the rule applies to, relative to the root of
+	// the walk. It is empty for the root itself
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored rules match the path relative to base, the others match the
+	// name of the file at any depth
+	anchored bool
+}
+
+// parseIgnoreRule parses a line of a .gitignore file. It returns false for
+// blank lines and comments
+func parseIgnoreRule(line, base string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	rule.anchored = strings.Contains(line, "/")
+	rule.pattern = strings.TrimPrefix(line, "/")
+	if rule.pattern == "" {
+		return ignoreRule{}, false
+	}
+	return rule, true
+}
+
+// readIgnoreFiles reads the rules of the ignore files in dir. base is the
+// path of dir relative to the root of the walk
+func readIgnoreFiles(dir, base string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, name := range ignoreFiles {
+		file, err := os.Open(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(scanner.Text(), base); ok {
+				rules = append(rules, rule)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// match reports whether the rule matches the file at rel, a slash-separated
+// path relative to the root of the walk
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, r.base+"/")
+	}
+	if !r.anchored {
+		return matchSegments([]string{r.pattern}, []string{path.Base(rel)})
+	}
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches a path against a pattern, both split on slashes. A
+// `**` segment matches any number of segments
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// ignored reports whether the file at rel is ignored by rules. The last
+// rule that matches wins, so that negated rules can re-include files
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	ignore := false
+	for _, rule := range rules {
+		if rule.match(rel, isDir) {
+			ignore = !rule.negate
+		}
+	}
+	return ignore
+}
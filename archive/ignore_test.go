@@ -0,0 +1,43 @@
+package archive
+
+import "testing"
+
+func TestIgnoreRule(t *testing.T) {
+	tests := []struct {
+		line  string
+		base  string
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"*.log", "", "a/b/debug.log", false, true},
+		{"build/", "", "src/build", true, true},
+		{"build/", "", "src/build", false, false},
+		{"/build", "", "src/build", true, false},
+		{"/build", "", "build", true, true},
+		{"doc/*.txt", "", "doc/notes.txt", false, true},
+		{"doc/*.txt", "", "doc/server/arch.txt", false, false},
+		{"**/foo", "", "a/b/foo", false, true},
+		{"a/**/b", "", "a/b", false, true},
+		{"a/**/b", "", "a/x/y/b", false, true},
+		{"logs/**", "", "logs/a/b", false, true},
+		{"*.md", "docs", "docs/draft.md", false, true},
+		{"*.md", "docs", "README.md", false, false},
+		{`\#notes`, "", "#notes", false, true},
+	}
+	for _, tt := range tests {
+		rule, ok := parseIgnoreRule(tt.line, tt.base)
+		if !ok {
+			t.Errorf("parseIgnoreRule(%q) is not a rule", tt.line)
+			continue
+		}
+		if got := rule.match(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("%q in %q matches %q = %v, want %v", tt.line, tt.base, tt.rel, got, tt.want)
+		}
+	}
+	for _, line := range []string{"", "# comment", "   "} {
+		if _, ok := parseIgnoreRule(line, ""); ok {
+			t.Errorf("parseIgnoreRule(%q) is a rule", line)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Tar writes the files and directories at paths to w as a tar archive,
+// keeping their permissions and symbolic links. The owners are not stored,
+// so that the archive is the same whoever creates it
+func Tar(w io.Writer, paths []string, opts Options) error {
+	tw := tar.NewWriter(w)
+	for _, root := range paths {
+		err := walk(root, opts, func(name, file string, info fs.FileInfo) error {
+			var link string
+			if info.Mode()&fs.ModeSymlink != 0 {
+				var err error
+				if link, err = os.Readlink(file); err != nil {
+					return err
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			header.Name = name
+			header.ModTime = modTime
+			header.AccessTime = time.Time{}
+			header.ChangeTime = time.Time{}
+			header.Uid, header.Gid = 0, 0
+			header.Uname, header.Gname = "", ""
+			if info.IsDir() {
+				header.Name += "/"
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Zip writes the files and directories at paths to w as a zip archive.
+// Symbolic links can't be stored, so those pointing to a regular file are
+// replaced by its content, and the others are skipped
+func Zip(w io.Writer, paths []string, opts Options) error {
+	zw := zip.NewWriter(w)
+	method := zip.Deflate
+	if opts.Level != nil {
+		if *opts.Level == 0 {
+			method = zip.Store
+		} else {
+			l := *opts.Level
+			zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(out, l)
+			})
+		}
+	}
+	for _, root := range paths {
+		err := walk(root, opts, func(name, file string, info fs.FileInfo) error {
+			if info.Mode()&fs.ModeSymlink != 0 {
+				target, err := os.Stat(file)
+				if err != nil || !target.Mode().IsRegular() {
+					return nil
+				}
+				info = target
+			}
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = name
+			header.Modified = modTime
+			if info.IsDir() {
+				header.Name += "/"
+				_, err := zw.CreateHeader(header)
+				return err
+			}
+			header.Method = method
+			entry, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(entry, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
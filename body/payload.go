@@ -1,10 +1,11 @@
 package body
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 
-	"github.com/claudiodangelis/qrcp/util"
+	"github.com/claudiodangelis/qrcp/archive"
 )
 
 // Body to transfer
@@ -12,6 +13,13 @@ type Body struct {
 	Filename            string
 	Path                string
 	DeleteAfterTransfer bool
+	// Files to archive, when the body is an archive created on the fly. Path
+	// is empty in that case
+	Files   []string
+	Archive archive.Options
+	// Reader streams the content, when its size is unknown and it can only
+	// be read once, like the standard input. Path is empty in that case
+	Reader io.Reader
 }
 
 // Delete the payload from disk
@@ -19,9 +27,56 @@ func (p Body) Delete() error {
 	return os.RemoveAll(p.Path)
 }
 
-// FromArgs returns a payload from args
-func FromArgs(args []string, zipFlag bool) (Body, error) {
-	shouldzip := len(args) > 1 || zipFlag
+// IsArchive reports whether the body is an archive created on the fly, which
+// is only written to disk if needed
+func (p Body) IsArchive() bool {
+	return p.Path == "" && len(p.Files) > 0
+}
+
+// WriteArchive writes the archive of the files of the body to w
+func (p Body) WriteArchive(w io.Writer) error {
+	return archive.Write(w, p.Files, p.Archive)
+}
+
+// Store writes the archive to a temporary file, and returns the body for
+// it. The file is deleted after the transfer
+func (p Body) Store() (Body, error) {
+	tmpfile, err := os.CreateTemp("", "qrcp*"+p.Archive.Extension())
+	if err != nil {
+		return Body{}, err
+	}
+	if err := p.WriteArchive(tmpfile); err != nil {
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
+		return Body{}, err
+	}
+	if err := tmpfile.Close(); err != nil {
+		os.Remove(tmpfile.Name())
+		return Body{}, err
+	}
+	return Body{
+		Filename:            p.Filename,
+		Path:                tmpfile.Name(),
+		DeleteAfterTransfer: true,
+	}, nil
+}
+
+// FromReader returns a payload that streams the content of r, with the given
+// filename
+func FromReader(r io.Reader, filename string) Body {
+	return Body{
+		Filename: filename,
+		Reader:   r,
+	}
+}
+
+// FromArgs returns a payload from args. Multiple args and directories are
+// archived, a single file only if archiveFlag is set
+func FromArgs(args []string, archiveFlag bool, opts archive.Options) (Body, error) {
+	if err := opts.Validate(); err != nil {
+		return Body{}, err
+	}
+	shouldzip := len(args) > 1 || archiveFlag
 	var files []string
 	// Check if content exists
 	for _, arg := range args {
@@ -29,27 +84,28 @@ func FromArgs(args []string, zipFlag bool) (Body, error) {
 		if err != nil {
 			return Body{}, err
 		}
-		// If at least one argument is dir, the content will be zipped
+		// If at least one argument is dir, the content will be archived
 		if file.IsDir() {
 			shouldzip = true
 		}
 		files = append(files, arg)
 	}
-	// Prepare the content
-	// TODO: Research cleaner code
-	var content string
+	// The archive is created while it is downloaded
 	if shouldzip {
-		zip, err := util.ZipFiles(files)
-		if err != nil {
-			return Body{}, err
+		filename := "qrcp"
+		if len(files) == 1 {
+			if abs, err := filepath.Abs(files[0]); err == nil && filepath.Dir(abs) != abs {
+				filename = filepath.Base(abs)
+			}
 		}
-		content = zip
-	} else {
-		content = args[0]
+		return Body{
+			Filename: filename + opts.Extension(),
+			Files:    files,
+			Archive:  opts,
+		}, nil
 	}
 	return Body{
-		Path:                content,
-		Filename:            filepath.Base(content),
-		DeleteAfterTransfer: shouldzip,
+		Path:     args[0],
+		Filename: filepath.Base(args[0]),
 	}, nil
 }
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/claudiodangelis/qrcp/server"
+)
+
+// approvalPrompt asks on the terminal whether to accept the clients waiting
+// for approval, one at a time, and answers with the keys pressed
+type approvalPrompt struct {
+	mu  sync.Mutex
+	srv *server.Server
+	out io.Writer
+	// pending are the clients waiting for an answer, the first one is the
+	// one being asked about
+	pending []server.Event
+}
+
+// subscribeApprovals asks for the approval of the new clients of srv on out,
+// it returns nil if clients don't have to be approved
+func subscribeApprovals(srv *server.Server, approve bool, out io.Writer) *approvalPrompt {
+	if !approve {
+		return nil
+	}
+	p := &approvalPrompt{srv: srv, out: out}
+	srv.Subscribe(p.request)
+	return p
+}
+
+// request is a listener that queues the clients to approve
+func (p *approvalPrompt) request(e server.Event) {
+	if e.Type != server.EventApprovalRequested {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, e)
+	if len(p.pending) == 1 {
+		p.ask()
+	}
+}
+
+// ask about the first pending client. The prompt is printed even with
+// --quiet, as it has to be answered
+func (p *approvalPrompt) ask() {
+	e := p.pending[0]
+	fmt.Fprintf(p.out, "Accept the connection from %s (%s)? [y/n]\n", e.ClientIP, e.UserAgent)
+}
+
+// answer the pending prompt with char. It reports whether char was an
+// answer, other keys are left to the caller
+func (p *approvalPrompt) answer(char rune) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) == 0 {
+		return false
+	}
+	var accept bool
+	switch char {
+	case 'y', 'Y':
+		accept = true
+	case 'n', 'N':
+		accept = false
+	default:
+		return false
+	}
+	e := p.pending[0]
+	p.pending = p.pending[1:]
+	p.srv.Approve(e.ClientIP, accept)
+	if accept {
+		fmt.Fprintln(p.out, "Accepted", e.ClientIP)
+	} else {
+		fmt.Fprintln(p.out, "Denied", e.ClientIP)
+	}
+	if len(p.pending) > 0 {
+		p.ask()
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import "strconv"
+
+// optionalBool is a boolean flag that stays nil when it is not passed, so
+// that the configuration file or a default can apply
+type optionalBool struct {
+	value **bool
+}
+
+func (b optionalBool) String() string {
+	if *b.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(**b.value)
+}
+
+func (b optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b.value = &v
+	return nil
+}
+
+func (b optionalBool) Type() string {
+	return "bool"
+}
+
+func (b optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+// optionalInt is an integer flag that stays nil when it is not passed
+type optionalInt struct {
+	value **int
+}
+
+func (i optionalInt) String() string {
+	if *i.value == nil {
+		return ""
+	}
+	return strconv.Itoa(**i.value)
+}
+
+func (i optionalInt) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*i.value = &v
+	return nil
+}
+
+func (i optionalInt) Type() string {
+	return "int"
+}
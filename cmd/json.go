@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/logger"
+	"github.com/claudiodangelis/qrcp/server"
+)
+
+// progressInterval is the minimum time between two progress events of the
+// same file, so that scripts are not flooded with them
+const progressInterval = 250 * time.Millisecond
+
+// jsonEvent is a line printed with --output-format json. Only the fields that
+// make sense for the event are set
+type jsonEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// Set on ready
+	URL             string `json:"url,omitempty"`
+	BaseURL         string `json:"base_url,omitempty"`
+	Bind            string `json:"bind,omitempty"`
+	Port            int    `json:"port,omitempty"`
+	PIN             string `json:"pin,omitempty"`
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+	// Set on client-connected
+	ClientIP  string `json:"client_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Set on transfers. Sizes are pointers, because zero is a valid size
+	File   string `json:"file,omitempty"`
+	Bytes  *int64 `json:"bytes,omitempty"`
+	Total  *int64 `json:"total,omitempty"`
+	Size   *int64 `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Rate is the current speed of the file, measured over the last few
+	// seconds, in bytes per second, set on progress
+	Rate *int64 `json:"rate,omitempty"`
+	// Set on shutdown
+	Reason string `json:"reason,omitempty"`
+	// Set on hook
+	Hook     string `json:"hook,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	// Set on error
+	Error string `json:"error,omitempty"`
+}
+
+// jsonOutput prints events as newline-delimited JSON
+type jsonOutput struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	// lastProgress is when the last progress event of each file was printed
+	lastProgress map[string]time.Time
+	// meters measure the speed of each file being transferred
+	meters map[string]*rateMeter
+}
+
+func newJSONOutput(out io.Writer) *jsonOutput {
+	return &jsonOutput{
+		enc:          json.NewEncoder(out),
+		lastProgress: make(map[string]time.Time),
+		meters:       make(map[string]*rateMeter),
+	}
+}
+
+// print writes e on its own line
+func (o *jsonOutput) print(e jsonEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e.Time = time.Now()
+	// There is nowhere else to report a failure to write
+	_ = o.enc.Encode(e)
+}
+
+// ready prints the event telling that srv accepts transfers at url
+func (o *jsonOutput) ready(srv *server.Server, url string) {
+	e := jsonEvent{
+		Event:           "ready",
+		URL:             url,
+		BaseURL:         srv.BaseURL,
+		PIN:             srv.PIN,
+		CertFingerprint: srv.CertFingerprint,
+	}
+	if addr, ok := srv.Addr().(*net.TCPAddr); ok {
+		e.Bind = addr.IP.String()
+		e.Port = addr.Port
+	}
+	o.print(e)
+}
+
+// events is a listener that prints the server events
+func (o *jsonOutput) events(e server.Event) {
+	line := jsonEvent{Event: string(e.Type), File: e.File}
+	switch e.Type {
+	case server.EventClientConnected, server.EventApprovalRequested:
+		line.ClientIP = e.ClientIP
+		line.UserAgent = e.UserAgent
+	case server.EventFileStarted:
+		meter := &rateMeter{}
+		meter.add(time.Now(), 0)
+		o.mu.Lock()
+		o.meters[e.ID] = meter
+		o.mu.Unlock()
+		line.ClientIP = e.ClientIP
+	case server.EventTransferStarted:
+		if e.Total >= 0 {
+			line.Total = &e.Total
+		}
+	case server.EventProgress:
+		o.mu.Lock()
+		last := o.lastProgress[e.ID]
+		if time.Since(last) < progressInterval {
+			o.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		o.lastProgress[e.ID] = now
+		if meter, ok := o.meters[e.ID]; ok {
+			rate := int64(meter.add(now, e.Bytes))
+			line.Rate = &rate
+		}
+		o.mu.Unlock()
+		line.Bytes = &e.Bytes
+		if e.Total >= 0 {
+			line.Total = &e.Total
+		}
+	case server.EventFileCompleted:
+		o.mu.Lock()
+		delete(o.lastProgress, e.ID)
+		delete(o.meters, e.ID)
+		o.mu.Unlock()
+		// The size is unknown when a file is sent
+		if e.Total >= 0 {
+			line.Size = &e.Total
+		}
+		line.SHA256 = e.SHA256
+	case server.EventShutdown:
+		line.Reason = e.Reason
+	case server.EventHook:
+		line.Hook = e.Hook
+		line.ExitCode = &e.ExitCode
+		if e.Err != nil {
+			line.Error = e.Err.Error()
+		}
+	case server.EventError:
+		line.Error = e.Err.Error()
+	}
+	o.print(line)
+}
+
+// subscribeOutput subscribes the printer of the events chosen with
+// --output-format to srv. It returns the JSON output, or nil for text
+func subscribeOutput(srv *server.Server, log logger.Logger) *jsonOutput {
+	if app.Flags.OutputFormat != "json" {
+		srv.Subscribe(printEvents(log.Writer()))
+		return nil
+	}
+	out := newJSONOutput(os.Stdout)
+	srv.Subscribe(out.events)
+	return out
+}
+
+// checkOutputFormat validates the --output-format flag
+func checkOutputFormat() error {
+	switch app.Flags.OutputFormat {
+	case "text", "json":
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected text or json", app.Flags.OutputFormat)
+}
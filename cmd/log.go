@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/claudiodangelis/qrcp/logger"
+)
+
+// newStructuredLogger returns the logger passed to the server, configured
+// with the --log-* flags
+func newStructuredLogger() (*slog.Logger, io.Closer, error) {
+	return logger.NewStructured(logger.Options{
+		Level:  app.Flags.LogLevel,
+		Format: app.Flags.LogFormat,
+		File:   app.Flags.LogFile,
+		Quiet:  app.Flags.Quiet,
+	})
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/logger"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/util"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+const (
+	// refreshInterval is the minimum delay between two drawings of the
+	// progress bars
+	refreshInterval = 200 * time.Millisecond
+	// lineInterval is the minimum delay between two lines reporting the
+	// progress of a file, when the output is not a terminal
+	lineInterval = 5 * time.Second
+	// rateWindow is the period over which the current speed of a file is
+	// measured
+	rateWindow = 3 * time.Second
+)
+
+// progressView renders the server events to out. On a terminal every file
+// being transferred has a progress bar, redrawn in place, otherwise a line
+// is printed when a file starts, every few seconds while it is transferred,
+// and when it is done. A summary of the transfers is printed when the server
+// shuts down
+type progressView struct {
+	mu  sync.Mutex
+	out io.Writer
+	tty bool
+	// bars of the files being transferred, in the order they started
+	bars []*fileBar
+	// drawn is the number of bars on the screen, overwritten by the next
+	// drawing
+	drawn    int
+	lastDraw time.Time
+	// files and bytes are the whole files transferred, between first and
+	// last
+	files       int
+	bytes       int64
+	first, last time.Time
+}
+
+// fileBar is the progress of a file, identified by the ID of its events
+type fileBar struct {
+	id      string
+	label   string
+	bar     *pb.ProgressBar
+	started time.Time
+	// lastLine is when the progress was last printed as a line
+	lastLine time.Time
+	meter    rateMeter
+}
+
+// rateMeter measures the current speed of a file from the bytes
+// transferred so far, over the last rateWindow
+type rateMeter struct {
+	samples []rateSample
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// add records that bytes have been transferred at now, and returns the
+// speed over the window in bytes per second
+func (m *rateMeter) add(now time.Time, bytes int64) float64 {
+	m.samples = append(m.samples, rateSample{at: now, bytes: bytes})
+	// The oldest sample is the start of the window
+	for len(m.samples) > 2 && now.Sub(m.samples[1].at) >= rateWindow {
+		m.samples = m.samples[1:]
+	}
+	first := m.samples[0]
+	elapsed := now.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes-first.bytes) / elapsed
+}
+
+// printEvents returns a listener that renders the server events to out
+func printEvents(out io.Writer) func(server.Event) {
+	v := &progressView{out: out, tty: isTerminal(out)}
+	return v.events
+}
+
+// isTerminal reports whether the bars can be redrawn in place on out
+func isTerminal(out io.Writer) bool {
+	// The Windows console doesn't understand the escape sequences used to
+	// move the cursor, unless told to
+	if runtime.GOOS == "windows" {
+		return false
+	}
+	f, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (v *progressView) events(e server.Event) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch e.Type {
+	case server.EventFileStarted:
+		label := filepath.Base(e.File)
+		if e.ClientIP != "" {
+			label += " from " + e.ClientIP
+		}
+		now := time.Now()
+		if v.first.IsZero() {
+			v.first = now
+		}
+		total := e.Total
+		if total < 0 {
+			total = 0
+		}
+		// The speed shown is the current one, not the average of pb
+		bar := pb.New64(total).SetUnits(pb.U_BYTES).Prefix(label + " ")
+		bar.ShowSpeed = false
+		bar.ManualUpdate = true
+		bar.NotPrint = true
+		if width, err := pb.GetTerminalWidth(); err == nil {
+			// Leave the last column empty, so that the line doesn't wrap
+			bar.SetMaxWidth(width - 1)
+		}
+		bar.Start()
+		b := &fileBar{id: e.ID, label: label, bar: bar, started: now, lastLine: now}
+		b.meter.add(now, 0)
+		v.bars = append(v.bars, b)
+		if v.tty {
+			v.draw()
+			return
+		}
+		fmt.Fprintln(v.out, "Transferring file:", label)
+	case server.EventProgress:
+		b := v.find(e.ID)
+		if b == nil {
+			return
+		}
+		// The size of a file sent is only known once the response starts
+		if b.bar.Total == 0 && e.Total > 0 {
+			b.bar.SetTotal64(e.Total)
+			b.bar.ShowPercent = true
+			b.bar.ShowTimeLeft = true
+		}
+		b.bar.Set64(e.Bytes)
+		rate := formatRate(b.meter.add(time.Now(), e.Bytes))
+		b.bar.Postfix(" " + rate)
+		if v.tty {
+			if time.Since(v.lastDraw) >= refreshInterval {
+				v.draw()
+			}
+			return
+		}
+		if time.Since(b.lastLine) >= lineInterval {
+			b.lastLine = time.Now()
+			size := formatBytes(e.Bytes)
+			if e.Total >= 0 {
+				size += " / " + formatBytes(e.Total)
+			}
+			fmt.Fprintf(v.out, "Transferring file: %s, %s (%s)\n", b.label, size, rate)
+		}
+	case server.EventFileCompleted:
+		b := v.remove(e.ID)
+		// Ranges of a download have no checksum, only whole files are
+		// reported
+		if e.SHA256 == "" {
+			if b != nil && v.tty {
+				v.draw()
+			}
+			return
+		}
+		lines := []string{}
+		if b != nil {
+			elapsed := time.Since(b.started)
+			lines = append(lines, fmt.Sprintf("Transferred %s: %s in %s (%s)",
+				e.File, formatBytes(e.Bytes), elapsed.Round(time.Millisecond), formatSpeed(e.Bytes, elapsed)))
+		}
+		lines = append(lines, fmt.Sprintf("SHA-256: %s  %s", e.SHA256, e.File))
+		v.files++
+		v.bytes += e.Bytes
+		v.last = time.Now()
+		v.print(lines...)
+	case server.EventHook:
+		// Failures are logged as warnings by the server
+		if e.Err == nil {
+			v.print(fmt.Sprintf("The %s hook for %s exited with status %d", e.Hook, e.File, e.ExitCode))
+		}
+	case server.EventError:
+		if b := v.remove(e.ID); b != nil && v.tty {
+			v.draw()
+		}
+	case server.EventShutdown:
+		// Transfers still running are interrupted
+		v.bars = nil
+		if v.files == 0 {
+			if v.tty && v.drawn > 0 {
+				v.draw()
+			}
+			return
+		}
+		elapsed := v.last.Sub(v.first)
+		v.print(fmt.Sprintf("Transferred %d file(s), %s in %s (%s on average)",
+			v.files, formatBytes(v.bytes), elapsed.Round(time.Millisecond), formatSpeed(v.bytes, elapsed)))
+	}
+}
+
+// printRateLimit tells the transfer rate limits set with --limit-rate and
+// --limit-rate-per-connection, if any
+func printRateLimit(log logger.Logger, limit, perConnection string) {
+	// The limits have already been validated by the server
+	if rate, err := util.ParseRate(limit); err == nil {
+		log.Print("Transfer rate limited to", formatSpeed(rate, time.Second))
+	}
+	if rate, err := util.ParseRate(perConnection); err == nil {
+		log.Print("Transfer rate limited to", formatSpeed(rate, time.Second), "per connection")
+	}
+}
+
+// find returns the bar of the file with the given ID, nil if there is none
+func (v *progressView) find(id string) *fileBar {
+	for _, b := range v.bars {
+		if b.id == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// remove the bar of the file with the given ID, and return it
+func (v *progressView) remove(id string) *fileBar {
+	for i, b := range v.bars {
+		if b.id == id {
+			v.bars = append(v.bars[:i], v.bars[i+1:]...)
+			return b
+		}
+	}
+	return nil
+}
+
+// print lines, above the bars on a terminal
+func (v *progressView) print(lines ...string) {
+	if v.tty {
+		v.draw(lines...)
+		return
+	}
+	for _, line := range lines {
+		fmt.Fprintln(v.out, line)
+	}
+}
+
+// draw the bars in place of the previous ones, after printing lines
+func (v *progressView) draw(lines ...string) {
+	var screen strings.Builder
+	if v.drawn > 0 {
+		// Go back to the first bar
+		fmt.Fprintf(&screen, "\033[%dA", v.drawn)
+	}
+	// Clear the bars, some may be gone
+	screen.WriteString("\r\033[J")
+	for _, line := range lines {
+		screen.WriteString(line + "\n")
+	}
+	for _, b := range v.bars {
+		b.bar.Update()
+		screen.WriteString(b.bar.String() + "\n")
+	}
+	v.drawn = len(v.bars)
+	v.lastDraw = time.Now()
+	io.WriteString(v.out, screen.String())
+}
+
+// formatBytes returns n in a human readable way, like 64.20 KiB
+func formatBytes(n int64) string {
+	return pb.Format(n).To(pb.U_BYTES).String()
+}
+
+// formatSpeed returns the average speed of n bytes transferred in elapsed
+func formatSpeed(n int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "-"
+	}
+	return formatRate(float64(n) / elapsed.Seconds())
+}
+
+// formatRate returns a speed in bytes per second, like 1.20 MiB/s
+func formatRate(rate float64) string {
+	return pb.Format(int64(rate)).To(pb.U_BYTES).PerSec().String()
+}
@@ -21,18 +21,49 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.ListAllInterfaces, "list-all-interfaces", "l", false, "list all available interfaces when choosing the one to use")
 	rootCmd.PersistentFlags().IntVarP(&app.Flags.Port, "port", "p", 0, "port to use for the server")
 	rootCmd.PersistentFlags().StringVar(&app.Flags.Path, "path", "", "path to use. Defaults to a random string")
+	rootCmd.PersistentFlags().IntVar(&app.Flags.PathLength, "path-length", 0, "length of the random path, in characters or words")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.PathAlphabet, "path-alphabet", "", "alphabet of the random path: alphanumeric, numeric, hex, words or a custom set of characters")
 	rootCmd.PersistentFlags().StringVarP(&app.Flags.Interface, "interface", "i", "", "network interface to use for the server")
 	rootCmd.PersistentFlags().StringVar(&app.Flags.Bind, "bind", "", "address to bind the web server to")
 	rootCmd.PersistentFlags().StringVarP(&app.Flags.FQDN, "fqdn", "d", "", "fully-qualified domain name to use for the resulting URLs")
-	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Zip, "zip", "z", false, "zip content before transferring")
+	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Zip, "zip", "z", false, "archive content before transferring, even a single file")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.Archive, "archive", "", "archive format: zip, tar, tgz or tzst")
+	rootCmd.PersistentFlags().Var(optionalInt{&app.Flags.CompressionLevel}, "compression-level", "compression level of archives, from 0 (no compression) to 9")
+	rootCmd.PersistentFlags().StringArrayVar(&app.Flags.Exclude, "exclude", nil, "skip the files matching this pattern when archiving directories, can be repeated")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.IncludeHidden, "include-hidden", false, "archive the hidden files found in directories")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.RespectGitignore, "respect-gitignore", false, "skip the files ignored by .gitignore and .qrcpignore when archiving directories")
 	rootCmd.PersistentFlags().StringVarP(&app.Flags.Config, "config", "c", "", "path to the config file, defaults to $XDG_CONFIG_HOME/qrcp/config.json")
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Browser, "browser", "b", false, "display the QR code in a browser window")
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Secure, "secure", "s", false, "use https connection")
 	rootCmd.PersistentFlags().StringVar(&app.Flags.TlsCert, "tls-cert", "", "path to TLS certificate to use with HTTPS")
 	rootCmd.PersistentFlags().StringVar(&app.Flags.TlsKey, "tls-key", "", "path to TLS private key to use with HTTPS")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.TlsMinVersion, "tls-min-version", "", "minimum TLS version accepted with HTTPS, 1.2 or 1.3")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.TlsCiphers, "tls-ciphers", "", "TLS cipher profile to use with HTTPS, intermediate or modern")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.TlsCache, "tls-cache", false, "reuse the self-signed certificate generated when no TLS certificate is set")
+	rootCmd.PersistentFlags().VarPF(optionalBool{&app.Flags.HTTP2}, "http2", "", "use HTTP/2 with HTTPS, enabled by default").NoOptDefVal = "true"
+	rootCmd.PersistentFlags().StringVar(&app.Flags.Pin, "pin", "", "PIN to enter before the transfer starts, random if no value is passed")
+	rootCmd.PersistentFlags().Lookup("pin").NoOptDefVal = "random"
+	rootCmd.PersistentFlags().StringVar(&app.Flags.Name, "name", "", "name of the file sent from the standard input with `-`")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.OutputFormat, "output-format", "text", "format of the output: text, or json to print newline-delimited JSON events")
 	rootCmd.PersistentFlags().BoolVarP(&app.Flags.Reversed, "reversed", "r", false, "Reverse QR code (black text on white background)")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.OnSendComplete, "on-send-complete", "", "shell command to run when the file has been sent")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.AbortOnHookFailure, "abort-on-hook-failure", false, "stop the server when a hook fails, even if it is kept alive")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.WebhookURL, "webhook-url", "", "URL to POST the transfer events to")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.WebhookSecret, "webhook-secret", "", "secret used to sign the webhook payloads with HMAC-SHA256")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.LogLevel, "log-level", "", "level of the logs: debug, info, warn or error, defaults to warn")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.LogFormat, "log-format", "text", "format of the logs: text or json")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.LogFile, "log-file", "", "file to append the logs to, defaults to the standard error")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.MetricsAddr, "metrics-addr", "", "address to serve the Prometheus metrics on, like 127.0.0.1:9100")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.Approve, "approve", false, "ask on the terminal whether to accept every new client")
+	rootCmd.PersistentFlags().StringArrayVar(&app.Flags.Allow, "allow", nil, "only accept clients from this IP address or CIDR range, can be repeated")
+	rootCmd.PersistentFlags().StringArrayVar(&app.Flags.Deny, "deny", nil, "refuse clients from this IP address or CIDR range, can be repeated")
+	rootCmd.PersistentFlags().BoolVar(&app.Flags.SameSubnetOnly, "same-subnet-only", false, "only accept clients from the subnets of the chosen interface")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.LimitRate, "limit-rate", "", "maximum transfer rate in bytes per second of all the connections together, like 500K or 5M")
+	rootCmd.PersistentFlags().StringVar(&app.Flags.LimitRatePerConnection, "limit-rate-per-connection", "", "maximum transfer rate in bytes per second of each connection, like 500K or 5M")
 	// Receive command flags
+	receiveCmd.PersistentFlags().StringVar(&app.Flags.OnReceive, "on-receive", "", "shell command to run on every received file")
 	receiveCmd.PersistentFlags().StringVarP(&app.Flags.Output, "output", "o", "", "output directory for receiving files")
+	receiveCmd.PersistentFlags().BoolVar(&app.Flags.Stdout, "stdout", false, "write the received file to the standard output, only one file can be received")
 }
 
 // The root command (`qrcp`) is like a shortcut of the `send` command
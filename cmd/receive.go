@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 
 	"github.com/claudiodangelis/qrcp/config"
 	"github.com/claudiodangelis/qrcp/logger"
@@ -12,23 +16,64 @@ import (
 )
 
 func receiveCmdFunc(command *cobra.Command, args []string) error {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
+	if app.Flags.Stdout && app.Flags.OutputFormat == "json" {
+		return errors.New("--stdout can't be used with --output-format json, both write to the standard output")
+	}
 	log := logger.New(app.Flags.Quiet)
+	// Keep the standard output for the received content or the JSON events
+	if app.Flags.ReservesStdout() {
+		log.SetOutput(os.Stderr)
+	}
 	// Load configuration
-	cfg := config.New(app)
+	cfg, err := config.New(app)
+	if err != nil {
+		return err
+	}
 	// Create the server
-	srv, err := server.New(&cfg)
+	if server.UsesExternalIP(&cfg) {
+		log.Print("Retrieving the external IP...")
+	}
+	serverLog, closer, err := newStructuredLogger()
 	if err != nil {
 		return err
 	}
+	defer closer.Close()
+	srv, err := server.New(&cfg, serverLog)
+	if err != nil {
+		return err
+	}
+	output := subscribeOutput(srv, log)
+	prompt := subscribeApprovals(srv, cfg.Approve, log.Writer())
 	// Sets the output directory
-	if err := srv.ReceiveTo(cfg.Output); err != nil {
+	if app.Flags.Stdout {
+		srv.ReceiveToWriter(os.Stdout)
+	} else if err := srv.ReceiveTo(cfg.Output); err != nil {
+		return err
+	}
+	// Gracefully shutdown when an OS signal is received
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := srv.Start(ctx); err != nil {
 		return err
 	}
+	if output != nil {
+		output.ready(srv, srv.ReceiveURL)
+	}
 	// Prints the URL to scan to screen
 	log.Print(`Scan the following URL with a QR reader to start the file transfer, press CTRL+C or "q" to exit:`)
 	log.Print(srv.ReceiveURL)
 	// Renders the QR
-	qr.RenderString(srv.ReceiveURL, cfg.Reversed)
+	qr.RenderString(log.Writer(), srv.ReceiveURL, cfg.Reversed)
+	if srv.PIN != "" {
+		log.Print("PIN:", srv.PIN)
+	}
+	if srv.CertFingerprint != "" {
+		log.Print("TLS certificate SHA-256 fingerprint:", srv.CertFingerprint)
+	}
+	printRateLimit(log, cfg.LimitRate, cfg.LimitRatePerConnection)
 	if app.Flags.Browser {
 		srv.DisplayQR(srv.ReceiveURL)
 	}
@@ -39,11 +84,17 @@ func receiveCmdFunc(command *cobra.Command, args []string) error {
 		go func() {
 			for {
 				char, key, _ := keyboard.GetKey()
+				if prompt != nil && prompt.answer(char) {
+					continue
+				}
 				if string(char) == "q" || key == keyboard.KeyCtrlC {
 					srv.Shutdown()
 				}
 			}
 		}()
+	} else if prompt != nil {
+		srv.Close()
+		return fmt.Errorf("--approve needs a keyboard to accept the clients: %v", err)
 	} else {
 		log.Print(fmt.Sprintf("Warning: keyboard not detected: %v", err))
 	}
@@ -62,6 +113,8 @@ var receiveCmd = &cobra.Command{
 qrcp receive
 # Receive files in a specific directory
 qrcp receive --output /tmp
+# Receive a single file and extract it
+qrcp receive --stdout | tar x
 `,
 	RunE: receiveCmdFunc,
 }
@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 
+	"github.com/claudiodangelis/qrcp/archive"
 	"github.com/claudiodangelis/qrcp/body"
 	"github.com/claudiodangelis/qrcp/config"
 	"github.com/claudiodangelis/qrcp/logger"
@@ -14,21 +19,57 @@ import (
 )
 
 func sendCmdFunc(command *cobra.Command, args []string) error {
+	if err := checkOutputFormat(); err != nil {
+		return err
+	}
 	log := logger.New(app.Flags.Quiet)
-	body, err := body.FromArgs(args, app.Flags.Zip)
+	// Keep the standard output for the JSON events
+	if app.Flags.ReservesStdout() {
+		log.SetOutput(os.Stderr)
+	}
+	cfg, err := config.New(app)
+	if err != nil {
+		return err
+	}
+	body, err := bodyFromArgs(args, cfg)
+	if err != nil {
+		return err
+	}
+	if server.UsesExternalIP(&cfg) {
+		log.Print("Retrieving the external IP...")
+	}
+	serverLog, closer, err := newStructuredLogger()
 	if err != nil {
 		return err
 	}
-	cfg := config.New(app)
-	srv, err := server.New(&cfg)
+	defer closer.Close()
+	srv, err := server.New(&cfg, serverLog)
 	if err != nil {
 		return err
 	}
+	output := subscribeOutput(srv, log)
+	prompt := subscribeApprovals(srv, cfg.Approve, log.Writer())
 	// Sets the body
 	srv.Send(body)
+	// Gracefully shutdown when an OS signal is received
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := srv.Start(ctx); err != nil {
+		return err
+	}
+	if output != nil {
+		output.ready(srv, srv.SendURL)
+	}
 	log.Print(`Scan the following URL with a QR reader to start the file transfer, press CTRL+C or "q" to exit:`)
 	log.Print(srv.SendURL)
-	qr.RenderString(srv.SendURL, cfg.Reversed)
+	qr.RenderString(log.Writer(), srv.SendURL, cfg.Reversed)
+	if srv.PIN != "" {
+		log.Print("PIN:", srv.PIN)
+	}
+	if srv.CertFingerprint != "" {
+		log.Print("TLS certificate SHA-256 fingerprint:", srv.CertFingerprint)
+	}
+	printRateLimit(log, cfg.LimitRate, cfg.LimitRatePerConnection)
 	if app.Flags.Browser {
 		srv.DisplayQR(srv.SendURL)
 	}
@@ -39,11 +80,17 @@ func sendCmdFunc(command *cobra.Command, args []string) error {
 		go func() {
 			for {
 				char, key, _ := keyboard.GetKey()
+				if prompt != nil && prompt.answer(char) {
+					continue
+				}
 				if string(char) == "q" || key == keyboard.KeyCtrlC {
 					srv.Shutdown()
 				}
 			}
 		}()
+	} else if prompt != nil {
+		srv.Close()
+		return fmt.Errorf("--approve needs a keyboard to accept the clients: %v", err)
 	} else {
 		log.Print(fmt.Sprintf("Warning: keyboard not detected: %v", err))
 	}
@@ -53,6 +100,29 @@ func sendCmdFunc(command *cobra.Command, args []string) error {
 	return nil
 }
 
+// bodyFromArgs returns the body to send, `-` reads it from the standard input
+func bodyFromArgs(args []string, cfg config.Config) (body.Body, error) {
+	if len(args) == 1 && args[0] == "-" {
+		name := app.Flags.Name
+		if name == "" {
+			name = "stdin"
+		}
+		return body.FromReader(os.Stdin, name), nil
+	}
+	for _, arg := range args {
+		if arg == "-" {
+			return body.Body{}, errors.New("the standard input can't be sent along with other files")
+		}
+	}
+	return body.FromArgs(args, app.Flags.Zip, archive.Options{
+		Format:           cfg.Archive,
+		Level:            cfg.CompressionLevel,
+		Exclude:          cfg.Exclude,
+		IncludeHidden:    cfg.IncludeHidden,
+		RespectGitignore: cfg.RespectGitignore,
+	})
+}
+
 var sendCmd = &cobra.Command{
 	Use:     "send",
 	Short:   "Send a file(s) or directories from this host",
@@ -66,8 +136,14 @@ qrcp /path/file.gif
 qrcp /path/file1.gif /path/file2.gif
 # Zip the content of directory, then send the zip package
 qrcp /path/directory
+# Send the content of directory as a tarball compressed with zstd
+qrcp --archive tzst /path/directory
+# Send videos in a zip package without compressing them again
+qrcp --compression-level 0 /path/videos
 # Send file.gif by creating a webserver on port 8080
 qrcp --port 8080 /path/file.gif
+# Send the output of a command, it can be downloaded only once
+pg_dump db | qrcp send - --name dump.sql
 `,
 	Args: cobra.MinimumNArgs(1),
 	RunE: sendCmdFunc,
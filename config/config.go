@@ -18,23 +18,59 @@ import (
 )
 
 type Config struct {
-	Interface string `yaml:",omitempty"`
-	Port      int    `yaml:",omitempty"`
-	Bind      string `yaml:",omitempty"`
-	KeepAlive bool   `yaml:",omitempty"`
-	Path      string `yaml:",omitempty"`
-	Secure    bool   `yaml:",omitempty"`
-	TlsKey    string `yaml:",omitempty"`
-	TlsCert   string `yaml:",omitempty"`
-	FQDN      string `yaml:",omitempty"`
-	Output    string `yaml:",omitempty"`
-	Reversed  bool   `yaml:",omitempty"`
+	Interface        string   `yaml:",omitempty"`
+	Port             int      `yaml:",omitempty"`
+	Bind             string   `yaml:",omitempty"`
+	KeepAlive        bool     `yaml:",omitempty"`
+	Path             string   `yaml:",omitempty"`
+	PathLength       int      `yaml:",omitempty"`
+	PathAlphabet     string   `yaml:",omitempty"`
+	Secure           bool     `yaml:",omitempty"`
+	TlsKey           string   `yaml:",omitempty"`
+	TlsCert          string   `yaml:",omitempty"`
+	TlsCache         bool     `yaml:",omitempty"`
+	TlsMinVersion    string   `yaml:",omitempty"`
+	TlsCiphers       string   `yaml:",omitempty"`
+	HTTP2            *bool    `yaml:",omitempty"`
+	Archive          string   `yaml:",omitempty"`
+	CompressionLevel *int     `yaml:",omitempty"`
+	Exclude          []string `yaml:",omitempty"`
+	IncludeHidden    bool     `yaml:",omitempty"`
+	RespectGitignore bool     `yaml:",omitempty"`
+	Pin              string   `yaml:",omitempty"`
+	FQDN             string   `yaml:",omitempty"`
+	Output           string   `yaml:",omitempty"`
+	Reversed         bool     `yaml:",omitempty"`
+	// OnReceive and OnSendComplete are shell commands run after a transfer
+	OnReceive          string `yaml:",omitempty"`
+	OnSendComplete     string `yaml:",omitempty"`
+	AbortOnHookFailure bool   `yaml:",omitempty"`
+	// WebhookURL receives the transfer events, signed with WebhookSecret
+	WebhookURL    string `yaml:",omitempty"`
+	WebhookSecret string `yaml:",omitempty"`
+	// MetricsAddr is the address to serve the Prometheus metrics on
+	MetricsAddr string `yaml:",omitempty"`
+	// Approve asks on the terminal whether to accept every new client
+	Approve bool `yaml:",omitempty"`
+	// Allow and Deny are the IP addresses or CIDR ranges of the clients
+	// accepted and refused
+	Allow          []string `yaml:",omitempty"`
+	Deny           []string `yaml:",omitempty"`
+	SameSubnetOnly bool     `yaml:",omitempty"`
+	// LimitRate is the maximum transfer rate in bytes per second, like 5M,
+	// of all the connections together, and LimitRatePerConnection the one
+	// of every connection
+	LimitRate              string `yaml:",omitempty"`
+	LimitRatePerConnection string `yaml:",omitempty"`
 }
 
 var interactive bool = false
 
-func New(app application.App) Config {
+func New(app application.App) (Config, error) {
 	log := logger.New(app.Flags.Quiet)
+	if app.Flags.ReservesStdout() {
+		log.SetOutput(os.Stderr)
+	}
 	v := getViperInstance(app)
 	var err error
 	cfg := Config{}
@@ -59,12 +95,42 @@ func New(app application.App) Config {
 	cfg.Port = v.GetInt("port")
 	cfg.KeepAlive = v.GetBool("keepAlive")
 	cfg.Path = v.GetString("path")
+	cfg.PathLength = v.GetInt("path-length")
+	cfg.PathAlphabet = v.GetString("path-alphabet")
 	cfg.Secure = v.GetBool("secure")
 	cfg.TlsKey = v.GetString("tls-key")
 	cfg.TlsCert = v.GetString("tls-cert")
+	cfg.TlsCache = v.GetBool("tls-cache")
+	cfg.TlsMinVersion = v.GetString("tls-min-version")
+	cfg.TlsCiphers = v.GetString("tls-ciphers")
+	cfg.Archive = v.GetString("archive")
+	if v.IsSet("compression-level") {
+		level := v.GetInt("compression-level")
+		cfg.CompressionLevel = &level
+	}
+	cfg.Exclude = v.GetStringSlice("exclude")
+	cfg.IncludeHidden = v.GetBool("include-hidden")
+	cfg.RespectGitignore = v.GetBool("respect-gitignore")
+	if v.IsSet("http2") {
+		http2 := v.GetBool("http2")
+		cfg.HTTP2 = &http2
+	}
+	cfg.Pin = v.GetString("pin")
 	cfg.FQDN = v.GetString("fqdn")
 	cfg.Output = v.GetString("output")
 	cfg.Reversed = v.GetBool("reversed")
+	cfg.OnReceive = v.GetString("on-receive")
+	cfg.OnSendComplete = v.GetString("on-send-complete")
+	cfg.AbortOnHookFailure = v.GetBool("abort-on-hook-failure")
+	cfg.WebhookURL = v.GetString("webhook-url")
+	cfg.WebhookSecret = v.GetString("webhook-secret")
+	cfg.MetricsAddr = v.GetString("metrics-addr")
+	cfg.Approve = v.GetBool("approve")
+	cfg.Allow = v.GetStringSlice("allow")
+	cfg.Deny = v.GetStringSlice("deny")
+	cfg.SameSubnetOnly = v.GetBool("same-subnet-only")
+	cfg.LimitRate = v.GetString("limit-rate")
+	cfg.LimitRatePerConnection = v.GetString("limit-rate-per-connection")
 
 	// Override
 	if app.Flags.Interface != "" {
@@ -82,6 +148,12 @@ func New(app application.App) Config {
 	if app.Flags.Path != "" {
 		cfg.Path = app.Flags.Path
 	}
+	if app.Flags.PathLength != 0 {
+		cfg.PathLength = app.Flags.PathLength
+	}
+	if app.Flags.PathAlphabet != "" {
+		cfg.PathAlphabet = app.Flags.PathAlphabet
+	}
 	if app.Flags.Secure {
 		cfg.Secure = true
 	}
@@ -91,6 +163,35 @@ func New(app application.App) Config {
 	if app.Flags.TlsCert != "" {
 		cfg.TlsCert = app.Flags.TlsCert
 	}
+	if app.Flags.TlsCache {
+		cfg.TlsCache = true
+	}
+	if app.Flags.TlsMinVersion != "" {
+		cfg.TlsMinVersion = app.Flags.TlsMinVersion
+	}
+	if app.Flags.TlsCiphers != "" {
+		cfg.TlsCiphers = app.Flags.TlsCiphers
+	}
+	if app.Flags.Archive != "" {
+		cfg.Archive = app.Flags.Archive
+	}
+	if app.Flags.CompressionLevel != nil {
+		cfg.CompressionLevel = app.Flags.CompressionLevel
+	}
+	// Patterns passed as flags add to those of the configuration
+	cfg.Exclude = append(cfg.Exclude, app.Flags.Exclude...)
+	if app.Flags.IncludeHidden {
+		cfg.IncludeHidden = true
+	}
+	if app.Flags.RespectGitignore {
+		cfg.RespectGitignore = true
+	}
+	if app.Flags.HTTP2 != nil {
+		cfg.HTTP2 = app.Flags.HTTP2
+	}
+	if app.Flags.Pin != "" {
+		cfg.Pin = app.Flags.Pin
+	}
 	if app.Flags.FQDN != "" {
 		cfg.FQDN = app.Flags.FQDN
 	}
@@ -100,13 +201,45 @@ func New(app application.App) Config {
 	if app.Flags.Reversed {
 		cfg.Reversed = true
 	}
+	if app.Flags.OnReceive != "" {
+		cfg.OnReceive = app.Flags.OnReceive
+	}
+	if app.Flags.OnSendComplete != "" {
+		cfg.OnSendComplete = app.Flags.OnSendComplete
+	}
+	if app.Flags.AbortOnHookFailure {
+		cfg.AbortOnHookFailure = true
+	}
+	if app.Flags.Approve {
+		cfg.Approve = true
+	}
+	cfg.Allow = append(cfg.Allow, app.Flags.Allow...)
+	cfg.Deny = append(cfg.Deny, app.Flags.Deny...)
+	if app.Flags.SameSubnetOnly {
+		cfg.SameSubnetOnly = true
+	}
+	if app.Flags.LimitRate != "" {
+		cfg.LimitRate = app.Flags.LimitRate
+	}
+	if app.Flags.LimitRatePerConnection != "" {
+		cfg.LimitRatePerConnection = app.Flags.LimitRatePerConnection
+	}
+	if app.Flags.WebhookURL != "" {
+		cfg.WebhookURL = app.Flags.WebhookURL
+	}
+	if app.Flags.WebhookSecret != "" {
+		cfg.WebhookSecret = app.Flags.WebhookSecret
+	}
+	if app.Flags.MetricsAddr != "" {
+		cfg.MetricsAddr = app.Flags.MetricsAddr
+	}
 
 	// Discover interface if it's not been set yet
 	if !interactive {
 		if cfg.Interface == "" {
 			cfg.Interface, err = chooseInterface(app.Flags)
 			if err != nil {
-				panic(err)
+				return cfg, err
 			}
 			v.Set("interface", cfg.Interface)
 			if err := v.WriteConfig(); err != nil {
@@ -115,7 +248,7 @@ func New(app application.App) Config {
 		}
 	}
 
-	return cfg
+	return cfg, nil
 }
 
 func getViperInstance(app application.App) *viper.Viper {
@@ -145,10 +278,12 @@ func getViperInstance(app application.App) *viper.Viper {
 
 func Wizard(app application.App) error {
 	interactive = true
-	cfg := New(app)
+	cfg, err := New(app)
+	if err != nil {
+		return err
+	}
 	v := getViperInstance(app)
 	// Choose interface
-	var err error
 	cfg.Interface, err = chooseInterface(app.Flags)
 	if err != nil {
 		panic(err)
@@ -224,6 +359,16 @@ func Wizard(app application.App) error {
 			v.Set("path", promptPathResultString)
 		}
 	}
+	if v.GetString("path") == "" {
+		// Ask for the style of the random path
+		promptPathAlphabet := promptui.Select{
+			Items: []string{"alphanumeric", "numeric", "hex", "words"},
+			Label: "Choose the style of random URL paths",
+		}
+		if _, promptPathAlphabetResultString, err := promptPathAlphabet.Run(); err == nil {
+			v.Set("path-alphabet", promptPathAlphabetResultString)
+		}
+	}
 	// Ask for keep alive
 	promptKeepAlive := promptui.Select{
 		Items: []string{"No", "Yes"},
@@ -267,23 +412,60 @@ func Wizard(app application.App) error {
 	if cfg.Secure {
 		// TLS Cert
 		promptTlsCert := promptui.Prompt{
-			Label:    "Choose TLS certificate path. Empty if not using HTTPS.",
-			Default:  cfg.TlsCert,
-			Validate: pathIsReadableFile,
+			Label:   "Choose TLS certificate path. Empty to generate a self-signed certificate.",
+			Default: cfg.TlsCert,
+			Validate: func(input string) error {
+				if input == "" {
+					return nil
+				}
+				return pathIsReadableFile(input)
+			},
 		}
 		if promptTlsCertString, err := promptTlsCert.Run(); err == nil {
 			v.Set("tls-cert", util.Expand(promptTlsCertString))
 		}
-		// TLS key
-		promptTlsKey := promptui.Prompt{
-			Label:    "Choose TLS certificate key. Empty if not using HTTPS.",
-			Default:  cfg.TlsKey,
-			Validate: pathIsReadableFile,
+		if v.GetString("tls-cert") != "" {
+			// TLS key, required along with the certificate
+			promptTlsKey := promptui.Prompt{
+				Label:    "Choose TLS certificate key.",
+				Default:  cfg.TlsKey,
+				Validate: pathIsReadableFile,
+			}
+			if promptTlsKeyString, err := promptTlsKey.Run(); err == nil {
+				v.Set("tls-key", util.Expand(promptTlsKeyString))
+			} else {
+				// The certificate can't be used without its key
+				v.Set("tls-cert", "")
+				v.Set("tls-key", "")
+			}
+		} else {
+			v.Set("tls-key", "")
+			// Cache the generated certificate
+			promptTlsCache := promptui.Select{
+				Items: []string{"No", "Yes"},
+				Label: "Should the self-signed certificate be reused across sessions?",
+			}
+			if _, promptTlsCacheResultString, err := promptTlsCache.Run(); err == nil {
+				v.Set("tls-cache", promptTlsCacheResultString == "Yes")
+			}
+		}
+		// TLS minimum version
+		promptTlsMinVersion := promptui.Select{
+			Items: []string{"1.2", "1.3"},
+			Label: "Choose the minimum TLS version accepted",
 		}
-		if promptTlsKeyString, err := promptTlsKey.Run(); err == nil {
-			v.Set("tls-key", util.Expand(promptTlsKeyString))
+		if _, promptTlsMinVersionResultString, err := promptTlsMinVersion.Run(); err == nil {
+			v.Set("tls-min-version", promptTlsMinVersionResultString)
 		}
 	}
+	// Archive format of folders and multiple files
+	promptArchive := promptui.Select{
+		Items: []string{"zip", "tar", "tgz", "tzst"},
+		Label: "Choose the archive format used to send folders and multiple files",
+	}
+	if _, promptArchiveResultString, err := promptArchive.Run(); err == nil {
+		v.Set("archive", promptArchiveResultString)
+	}
 	validateIsDir := func(input string) error {
 		if input == "" {
 			return nil
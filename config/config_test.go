@@ -31,6 +31,8 @@ func TestNew(t *testing.T) {
 	if err := os.WriteFile(partialconfig.Name(), []byte(`port: 9090`), os.ModePerm); err != nil {
 		panic(err)
 	}
+	disabled := false
+	stored := 0
 	type args struct {
 		app application.App
 	}
@@ -85,17 +87,41 @@ func TestNew(t *testing.T) {
 				},
 			},
 			Config{
-				Interface: foundIface,
-				Port:      18080,
-				KeepAlive: false,
-				Bind:      "10.20.30.40",
-				Path:      "random",
-				Secure:    false,
-				TlsKey:    "/path/to/key",
-				TlsCert:   "/path/to/cert",
-				FQDN:      "mylan.com",
-				Output:    "/path/to/default/output/dir",
-				Reversed:  true,
+				Interface:              foundIface,
+				Port:                   18080,
+				KeepAlive:              false,
+				Bind:                   "10.20.30.40",
+				Path:                   "random",
+				PathLength:             4,
+				PathAlphabet:           "words",
+				Secure:                 false,
+				TlsKey:                 "/path/to/key",
+				TlsCert:                "/path/to/cert",
+				TlsCache:               true,
+				TlsMinVersion:          "1.3",
+				TlsCiphers:             "modern",
+				HTTP2:                  &disabled,
+				Archive:                "tgz",
+				CompressionLevel:       &stored,
+				Exclude:                []string{"node_modules/", "*.log"},
+				IncludeHidden:          true,
+				RespectGitignore:       true,
+				Pin:                    "2468",
+				FQDN:                   "mylan.com",
+				Output:                 "/path/to/default/output/dir",
+				Reversed:               true,
+				OnReceive:              `ocrmypdf "$QRCP_FILE" "$QRCP_FILE"`,
+				OnSendComplete:         "echo sent",
+				AbortOnHookFailure:     true,
+				WebhookURL:             "https://chat.example.com/hooks/qrcp",
+				WebhookSecret:          "s3cret",
+				MetricsAddr:            "127.0.0.1:9100",
+				Approve:                true,
+				Allow:                  []string{"192.168.1.0/24"},
+				Deny:                   []string{"192.168.1.13"},
+				SameSubnetOnly:         true,
+				LimitRate:              "5M",
+				LimitRatePerConnection: "1M",
 			},
 		},
 		{
@@ -108,23 +134,50 @@ func TestNew(t *testing.T) {
 				},
 			},
 			Config{
-				Interface: foundIface,
-				Port:      99999,
-				Bind:      "10.20.30.40",
-				KeepAlive: false,
-				Path:      "random",
-				Secure:    false,
-				TlsKey:    "/path/to/key",
-				TlsCert:   "/path/to/cert",
-				FQDN:      "mylan.com",
-				Output:    "/path/to/default/output/dir",
-				Reversed:  true,
+				Interface:              foundIface,
+				Port:                   99999,
+				Bind:                   "10.20.30.40",
+				KeepAlive:              false,
+				Path:                   "random",
+				PathLength:             4,
+				PathAlphabet:           "words",
+				Secure:                 false,
+				TlsKey:                 "/path/to/key",
+				TlsCert:                "/path/to/cert",
+				TlsCache:               true,
+				TlsMinVersion:          "1.3",
+				TlsCiphers:             "modern",
+				HTTP2:                  &disabled,
+				Archive:                "tgz",
+				CompressionLevel:       &stored,
+				Exclude:                []string{"node_modules/", "*.log"},
+				IncludeHidden:          true,
+				RespectGitignore:       true,
+				Pin:                    "2468",
+				FQDN:                   "mylan.com",
+				Output:                 "/path/to/default/output/dir",
+				Reversed:               true,
+				OnReceive:              `ocrmypdf "$QRCP_FILE" "$QRCP_FILE"`,
+				OnSendComplete:         "echo sent",
+				AbortOnHookFailure:     true,
+				WebhookURL:             "https://chat.example.com/hooks/qrcp",
+				WebhookSecret:          "s3cret",
+				MetricsAddr:            "127.0.0.1:9100",
+				Approve:                true,
+				Allow:                  []string{"192.168.1.0/24"},
+				Deny:                   []string{"192.168.1.13"},
+				SameSubnetOnly:         true,
+				LimitRate:              "5M",
+				LimitRatePerConnection: "1M",
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := New(tt.args.app)
+			got, err := New(tt.args.app)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
 			got.Interface = foundIface
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("New() = %v, want %v", got, tt.want)
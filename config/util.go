@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"fmt"
+	"os"
 
 	"github.com/claudiodangelis/qrcp/application"
 	"github.com/claudiodangelis/qrcp/util"
@@ -17,9 +18,14 @@ func chooseInterface(flags application.Flags) (string, error) {
 	if len(interfaces) == 0 {
 		return "", errors.New("no interfaces found")
 	}
+	// Keep the standard output clean for the received file or JSON events
+	out := os.Stdout
+	if flags.ReservesStdout() {
+		out = os.Stderr
+	}
 	if len(interfaces) == 1 && !interactive {
 		for name := range interfaces {
-			fmt.Printf("only one interface found: %s, using this one\n", name)
+			fmt.Fprintf(out, "only one interface found: %s, using this one\n", name)
 			return name, nil
 		}
 	}
@@ -37,9 +43,14 @@ func chooseInterface(flags application.Flags) (string, error) {
 	anyLabel := fmt.Sprintf("%s (%s)", anyName, anyIP)
 	m[anyLabel] = anyName
 	items = append(items, anyLabel)
+	// The prompt reads the standard input, which may be the content to send
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return "", errors.New("the standard input is not a terminal to choose the network interface from, set it with --interface")
+	}
 	prompt := promptui.Select{
-		Items: items,
-		Label: "Choose interface",
+		Items:  items,
+		Label:  "Choose interface",
+		Stdout: out,
 	}
 	_, result, err := prompt.Run()
 	if err != nil {
@@ -2,23 +2,38 @@ package logger
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
 // Print prints its argument if the --quiet flag is not passed
 func (l Logger) Print(args ...interface{}) {
 	if !l.quiet {
-		fmt.Println(args...)
+		fmt.Fprintln(l.out, args...)
 	}
 }
 
+// SetOutput sets the destination of the messages, which is the standard
+// output by default
+func (l *Logger) SetOutput(w io.Writer) {
+	l.out = w
+}
+
+// Writer returns the destination of the messages
+func (l Logger) Writer() io.Writer {
+	return l.out
+}
+
 // Logger struct
 type Logger struct {
 	quiet bool
+	out   io.Writer
 }
 
 // New logger
 func New(quiet bool) Logger {
 	return Logger{
 		quiet: quiet,
+		out:   os.Stdout,
 	}
 }
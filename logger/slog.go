@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Options of the structured logger
+type Options struct {
+	// Level is debug, info, warn or error. It defaults to warn, or to error
+	// when Quiet is set
+	Level string
+	// Format is text or json, text by default
+	Format string
+	// File receives the logs in place of the standard error
+	File  string
+	Quiet bool
+}
+
+// NewStructured returns a log/slog logger configured with opts. The returned
+// closer releases the log file, if any
+func NewStructured(opts Options) (*slog.Logger, io.Closer, error) {
+	level := slog.LevelWarn
+	if opts.Quiet {
+		level = slog.LevelError
+	}
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", opts.Level)
+		}
+	}
+	var out io.WriteCloser = nopCloser{os.Stderr}
+	if opts.File != "" {
+		file, err := os.OpenFile(opts.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = file
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch opts.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(out, handlerOptions)
+	case "json":
+		handler = slog.NewJSONHandler(out, handlerOptions)
+	default:
+		out.Close()
+		return nil, nil, fmt.Errorf("invalid log format %q, expected text or json", opts.Format)
+	}
+	return slog.New(handler), out, nil
+}
+
+// Discard returns a logger that drops everything
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// nopCloser doesn't close the standard error along with the log file
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
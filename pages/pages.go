@@ -180,7 +180,7 @@ var Upload = `
                     <label for="files">
                         Files to transfer
                     </label>
-                    <input class="form-control-file" type="file" id="files" name="files" multiple>
+                    <input class="form-control-file" type="file" id="files" name="files"{{if not .Single}} multiple{{end}}>
                 </div>
                 <div class="form-group form-check">
                     <input type="checkbox" class="form-check-input" id="check-send-text">
@@ -274,39 +274,252 @@ var Upload = `
             }
         });
 
-        uploadForm.addEventListener('submit', function(e) {
-            e.preventDefault();
-
-            var xhr = new XMLHttpRequest();
-            xhr.onreadystatechange = function() {
-                if (xhr.readyState === 4) {
-                    document.write(xhr.response)
-                }
+        // Collect the files to transfer: selected, pasted and typed ones
+        function collectFiles() {
+            var files = []
+            var fileInput = document.getElementById('files')
+            for (var i = 0; i < fileInput.files.length; i++) {
+                files.push({ name: fileInput.files[i].name, blob: fileInput.files[i] })
             }
-
-            var formData = new FormData(uploadForm)
             var titleInput = document.getElementById('plaintext-title')
             var textInput = document.getElementById('plaintext-text')
             var textCheckbox = document.getElementById('check-send-text')
-
             if ((titleInput.value || textInput.value) && textCheckbox.checked) {
                 var currentDate = new Date().toJSON().slice(0,19).replace(/[-T:]/g,'_')
                 // If the user didn't specify a file name, use 'qrcp-text-file-${currentDate}'
                 var filename = titleInput.value || ("qrcp-text-file-" + currentDate)
                 var blob = new Blob([textInput.value + '\n'], { type: "text/plain" })
-                // Append the text file to the form data with '.txt' extension
-                formData.append("textFile", blob, filename + ".txt")
+                // Add the text file with '.txt' extension
+                files.push({ name: filename + ".txt", blob: blob })
             }
-
-            // Append pasted files to the form data
+            // Add pasted files
             for (var i = 0; i < pastedFiles.length; i++) {
                 var file = pastedFiles[i];
-                var fileName = file.name || ('pasted_file_' + i);
-                formData.append('files', file, fileName);
+                files.push({ name: file.name || ('pasted_file_' + i), blob: file })
+            }
+            return files
+        }
+
+        // Resumable uploads with the tus protocol, see https://tus.io
+        var tusEndpoint = "{{.Route}}/files/"
+        var tusChunkSize = 8 * 1024 * 1024
+        // Only one file can be sent when it is written to the output of qrcp
+        var singleFile = {{.Single}}
+
+        function tusRequest(method, url, headers, body, onProgress) {
+            return new Promise(function(resolve, reject) {
+                var xhr = new XMLHttpRequest()
+                xhr.open(method, url)
+                xhr.setRequestHeader('Tus-Resumable', '1.0.0')
+                for (var name in headers) {
+                    xhr.setRequestHeader(name, headers[name])
+                }
+                if (onProgress) {
+                    xhr.upload.onprogress = function(e) { onProgress(e.loaded) }
+                }
+                xhr.onload = function() { resolve(xhr) }
+                xhr.onerror = function() { reject(new Error('network error')) }
+                xhr.ontimeout = function() { reject(new Error('timeout')) }
+                xhr.send(body)
+            })
+        }
+
+        function sleep(ms) {
+            return new Promise(function(resolve) { setTimeout(resolve, ms) })
+        }
+
+        function encodeMetadata(value) {
+            return btoa(unescape(encodeURIComponent(value)))
+        }
+
+        // Create the upload, or find the one created before a page reload
+        function tusCreate(file) {
+            var key = 'qrcp:' + tusEndpoint + ':' + file.name + ':' + file.blob.size + ':' + (file.blob.lastModified || '')
+            var stored = window.localStorage && localStorage.getItem(key)
+            var lookup = stored ? tusRequest('HEAD', stored, {}) : Promise.resolve(null)
+            return lookup.catch(function() { return null }).then(function(xhr) {
+                if (xhr && xhr.status === 200) {
+                    var upload = { key: key, file: file, url: stored, offset: parseInt(xhr.getResponseHeader('Upload-Offset'), 10) }
+                    readDigest(upload, xhr)
+                    return upload
+                }
+                return tusRequest('POST', tusEndpoint, {
+                    'Upload-Length': file.blob.size,
+                    'Upload-Metadata': 'filename ' + encodeMetadata(file.name)
+                }).then(function(xhr) {
+                    if (xhr.status !== 201) {
+                        throw new Error(xhr.responseText || xhr.statusText)
+                    }
+                    var url = xhr.getResponseHeader('Location')
+                    if (window.localStorage) {
+                        localStorage.setItem(key, url)
+                    }
+                    var upload = { key: key, file: file, url: url, offset: 0 }
+                    readDigest(upload, xhr)
+                    return upload
+                })
+            })
+        }
+
+        // Send the content of the upload, resuming from the last offset known
+        // by the server whenever the connection drops
+        function tusUpload(upload, onProgress) {
+            var failures = 0
+            function next() {
+                if (upload.offset >= upload.file.blob.size) {
+                    return Promise.resolve(upload)
+                }
+                var chunk = upload.file.blob.slice(upload.offset, upload.offset + tusChunkSize)
+                return tusRequest('PATCH', upload.url, {
+                    'Content-Type': 'application/offset+octet-stream',
+                    'Upload-Offset': upload.offset
+                }, chunk, function(loaded) {
+                    onProgress(upload.offset + loaded)
+                }).then(function(xhr) {
+                    if (xhr.status === 204) {
+                        failures = 0
+                        upload.offset = parseInt(xhr.getResponseHeader('Upload-Offset'), 10)
+                        readDigest(upload, xhr)
+                        onProgress(upload.offset)
+                        return next()
+                    }
+                    if (xhr.status >= 400 && xhr.status < 500 && xhr.status !== 409 && xhr.status !== 423) {
+                        throw new Error(xhr.responseText || xhr.statusText)
+                    }
+                    return resume()
+                }, resume)
+            }
+            function resume() {
+                failures++
+                if (failures > 30) {
+                    return Promise.reject(new Error('the connection to the server was lost'))
+                }
+                var delay = Math.min(1000 * failures, 10000)
+                return sleep(delay).then(function() {
+                    return tusRequest('HEAD', upload.url, {})
+                }).then(function(xhr) {
+                    if (xhr.status !== 200) {
+                        throw new Error('the upload is no longer available')
+                    }
+                    upload.offset = parseInt(xhr.getResponseHeader('Upload-Offset'), 10)
+                    readDigest(upload, xhr)
+                    return next()
+                }, resume)
+            }
+            if (upload.file.blob.size === 0) {
+                return Promise.resolve(upload)
             }
+            return next()
+        }
+
+        // Read the SHA-256 checksum computed by the server from the
+        // Repr-Digest header of a response, and format it as hex
+        function readDigest(upload, xhr) {
+            var match = /sha-256=:([^:]+):/.exec(xhr.getResponseHeader('Repr-Digest') || '')
+            if (!match) {
+                return
+            }
+            var raw = atob(match[1])
+            var hex = ''
+            for (var i = 0; i < raw.length; i++) {
+                hex += ('0' + raw.charCodeAt(i).toString(16)).slice(-2)
+            }
+            upload.sha256 = hex
+        }
+
+        function showDone(uploads) {
+            document.body.innerHTML = '<div class="container"><div class="alert alert-success" role="alert">' +
+                '<h4 class="alert-heading">Done!</h4><p>Successfully transferred:</p><ul></ul>' +
+                '<p>You can close this page now.</p></div></div>'
+            var list = document.querySelector('ul')
+            uploads.forEach(function(upload) {
+                var item = document.createElement('li')
+                var name = document.createElement('b')
+                name.textContent = upload.file.name
+                item.appendChild(name)
+                if (upload.sha256) {
+                    var sum = document.createElement('code')
+                    sum.textContent = upload.sha256
+                    sum.style.wordBreak = 'break-all'
+                    item.appendChild(document.createElement('br'))
+                    item.appendChild(document.createTextNode('SHA-256: '))
+                    item.appendChild(sum)
+                }
+                list.appendChild(item)
+            })
+        }
+
+        function tusTransfer(files) {
+            var submit = document.getElementById('submit')
+            var total = files.reduce(function(sum, file) { return sum + file.blob.size }, 0)
+            var sent = {}
+            function onProgress(upload) {
+                return function(offset) {
+                    sent[upload.key] = offset
+                    var done = Object.keys(sent).reduce(function(sum, key) { return sum + sent[key] }, 0)
+                    submit.value = 'Transferring files, please wait. ' + (total ? Math.floor(done * 100 / total) : 100) + '%'
+                }
+            }
+            // Create all the uploads first, so that the server knows when the
+            // last one is complete
+            var uploads = []
+            var creation = Promise.resolve()
+            files.forEach(function(file) {
+                creation = creation.then(function() {
+                    return tusCreate(file).then(function(upload) { uploads.push(upload) })
+                })
+            })
+            return creation.then(function() {
+                var transfer = Promise.resolve()
+                uploads.forEach(function(upload) {
+                    transfer = transfer.then(function() {
+                        return tusUpload(upload, onProgress(upload))
+                    })
+                })
+                return transfer
+            }).then(function() {
+                uploads.forEach(function(upload) {
+                    if (window.localStorage) {
+                        localStorage.removeItem(upload.key)
+                    }
+                })
+                showDone(uploads)
+            })
+        }
 
+        // Plain multipart upload, for browsers without Promise support
+        function multipartTransfer(files) {
+            var xhr = new XMLHttpRequest();
+            xhr.onreadystatechange = function() {
+                if (xhr.readyState === 4) {
+                    document.write(xhr.response)
+                }
+            }
+            var formData = new FormData()
+            for (var i = 0; i < files.length; i++) {
+                formData.append('files', files[i].blob, files[i].name)
+            }
             xhr.open("POST", "{{.Route}}")
             xhr.send(formData)
+        }
+
+        uploadForm.addEventListener('submit', function(e) {
+            e.preventDefault();
+            var files = collectFiles()
+            if (singleFile && files.length > 1) {
+                var submit = document.getElementById('submit')
+                submit.value = 'Only one file can be sent, please select a single file'
+                submit.disabled = false
+                return
+            }
+            if (!window.Promise || !Blob.prototype.slice) {
+                multipartTransfer(files)
+                return
+            }
+            tusTransfer(files).catch(function(err) {
+                var submit = document.getElementById('submit')
+                submit.value = 'Transfer failed: ' + err.message
+            })
         })
     </script>
 </body>
@@ -333,6 +546,9 @@ var Done = `
         body {
             margin: 10px;
         }
+        code {
+            word-break: break-all;
+        }
     </style>
 </head>
 
@@ -340,11 +556,158 @@ var Done = `
     <div class="container">
         <div class="alert alert-success" role="alert">
             <h4 class="alert-heading">Done!</h4>
-            <p>
-                Successfully transferred to:<br/> <b>{{.File}}</b>.<br/> You can close this page now.
-            </p>
+            <p>Successfully transferred to:</p>
+            <ul>
+                {{range .Files}}<li><b>{{.Path}}</b><br/>SHA-256: <code>{{.SHA256}}</code></li>
+                {{end}}
+            </ul>
+            <p>You can close this page now.</p>
         </div>
     </div>
 </body>
 </html>
 `
+
+// Pin page, asks for the PIN before the transfer starts
+var Pin = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta http-equiv="x-ua-compatible" content="ie=edge">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+        input {
+            display: block;
+            width: 100%;
+            box-sizing: border-box;
+            margin-bottom: 10px;
+            padding: 10px;
+            font-size: 24px;
+        }
+        .error {
+            color: #a94442;
+        }
+    </style>
+</head>
+
+<body>
+    <form method="POST" action="{{.Route}}">
+        <h3>Enter the PIN shown on the screen</h3>
+        {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+        <input type="password" name="pin" inputmode="numeric" autocomplete="one-time-code" autofocus required>
+        <input type="submit" value="Continue">
+    </form>
+</body>
+</html>
+`
+
+// Approval page, waits until the client is accepted or denied on the
+// terminal
+var Approval = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta http-equiv="x-ua-compatible" content="ie=edge">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+        }
+        .error {
+            color: #a94442;
+        }
+    </style>
+</head>
+
+<body>
+    <h3 id="message">Waiting for approval</h3>
+    <p id="details">Accept the connection on the screen where qrcp is running.</p>
+    <script>
+        function poll() {
+            fetch(location.pathname + '?approval', { cache: 'no-store' })
+                .then(function (response) { return response.text() })
+                .then(function (state) {
+                    if (state === 'accepted') {
+                        document.getElementById('message').textContent = 'Approved'
+                        document.getElementById('details').textContent = ''
+                        location.reload()
+                    } else if (state === 'denied') {
+                        document.getElementById('message').textContent = 'The connection has been denied'
+                        document.getElementById('message').className = 'error'
+                        document.getElementById('details').textContent = ''
+                    } else {
+                        setTimeout(poll, 1000)
+                    }
+                })
+                .catch(function () { setTimeout(poll, 2000) })
+        }
+        poll()
+    </script>
+</body>
+</html>
+`
+
+// QR page, shows the QR code to scan and the progress of the transfers
+var QR = `
+<!doctype html>
+<html lang="en">
+
+<head>
+    <meta charset="utf-8">
+    <meta http-equiv="x-ua-compatible" content="ie=edge">
+    <meta name="viewport" content="width=device-width, user-scalable=no">
+    <title>qrcp</title>
+    <style>
+        body {
+            margin: 10px;
+            font-family: sans-serif;
+            text-align: center;
+        }
+        img {
+            max-width: 100%;
+        }
+        ul {
+            display: inline-block;
+            padding: 0;
+            text-align: left;
+            list-style: none;
+        }
+    </style>
+</head>
+
+<body>
+    <img src="{{.Image}}" alt="QR code">
+    <ul id="files"></ul>
+    <script>
+        var list = document.getElementById('files')
+        var events = new EventSource('{{.Events}}')
+        events.addEventListener('status', function (e) {
+            var status = JSON.parse(e.data)
+            list.textContent = ''
+            status.files.forEach(function (file) {
+                var progress = file.done ? 'done' : file.bytes + ' bytes'
+                if (!file.done && file.total > 0) {
+                    progress = Math.floor(100 * file.bytes / file.total) + '%'
+                }
+                var item = document.createElement('li')
+                item.textContent = file.file.split(/[\\/]/).pop() + ': ' + progress
+                list.appendChild(item)
+            })
+        })
+        // The stream ends when the server shuts down, keep the last status
+        events.onerror = function () { events.close() }
+    </script>
+</body>
+</html>
+`
@@ -3,18 +3,19 @@ package qr
 import (
 	"fmt"
 	"image"
+	"io"
 	"log"
 
 	"github.com/skip2/go-qrcode"
 )
 
-// RenderString as a QR code
-func RenderString(s string, inverseColor bool) {
+// RenderString as a QR code to w
+func RenderString(w io.Writer, s string, inverseColor bool) {
 	q, err := qrcode.New(s, qrcode.Medium)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println(q.ToSmallString(inverseColor))
+	fmt.Fprintln(w, q.ToSmallString(inverseColor))
 }
 
 // RenderImage returns a QR code as an image.Image
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// accessList tells which peers may connect to the server, from their IP
+// address
+type accessList struct {
+	// allow is empty when every peer not denied is allowed
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// newAccessList returns the access list set in cfg, nil if anyone may
+// connect
+func newAccessList(cfg *config.Config) (*accessList, error) {
+	allow, err := parseNetworks(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %v", err)
+	}
+	deny, err := parseNetworks(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %v", err)
+	}
+	if cfg.SameSubnetOnly {
+		networks, err := util.InterfaceNetworks(cfg.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the subnet of interface %s: %v", cfg.Interface, err)
+		}
+		allow = append(allow, networks...)
+	}
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	return &accessList{allow: allow, deny: deny}, nil
+}
+
+// parseNetworks parses CIDR ranges, a single IP address is a range of its
+// own
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or a CIDR range", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or a CIDR range", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// permits reports whether the peer at ip may connect. Denied ranges take
+// precedence over allowed ones
+func (l *accessList) permits(ip net.IP) bool {
+	for _, network := range l.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, network := range l.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
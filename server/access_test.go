@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestAccessList(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		ip    string
+		want  bool
+	}{
+		{"allowed range", []string{"192.168.1.0/24"}, nil, "192.168.1.20", true},
+		{"outside allowed range", []string{"192.168.1.0/24"}, nil, "192.168.2.20", false},
+		{"denied address", nil, []string{"192.168.1.13"}, "192.168.1.13", false},
+		{"not denied", nil, []string{"192.168.1.13"}, "192.168.1.14", true},
+		{"deny takes precedence", []string{"192.168.1.0/24"}, []string{"192.168.1.13"}, "192.168.1.13", false},
+		{"IPv4-mapped IPv6", []string{"10.0.0.0/8"}, nil, "::ffff:10.1.2.3", true},
+		{"IPv6 range", []string{"fd00::/8"}, nil, "fd12::1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := newAccessList(&config.Config{Allow: tt.allow, Deny: tt.deny})
+			if err != nil {
+				t.Fatalf("newAccessList() error = %v", err)
+			}
+			if got := list.permits(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("permits(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+	if list, err := newAccessList(&config.Config{}); list != nil || err != nil {
+		t.Errorf("newAccessList() without lists = %v, %v, want nil", list, err)
+	}
+	if _, err := newAccessList(&config.Config{Allow: []string{"192.168.1.0/33"}}); err == nil {
+		t.Error("newAccessList() with an invalid range error = nil")
+	}
+}
+
+func TestAccessListRefusesConnections(t *testing.T) {
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", Deny: []string{"127.0.0.0/8"}}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(t.TempDir()); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	connected := false
+	srv.Subscribe(func(e Event) {
+		if e.Type == EventClientConnected {
+			connected = true
+		}
+	})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	if resp, err := http.Get(srv.ReceiveURL); err == nil {
+		resp.Body.Close()
+		t.Errorf("GET %s status = %d, want the connection to be closed", srv.ReceiveURL, resp.StatusCode)
+	}
+	if connected {
+		t.Error("a refused client reached the handlers")
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/claudiodangelis/qrcp/pages"
+)
+
+// States of an approval, as reported to the waiting page
+const (
+	approvalPending  = "pending"
+	approvalAccepted = "accepted"
+	approvalDenied   = "denied"
+)
+
+// approvalGate holds the requests of every new client until it is accepted
+// or denied with Server.Approve. Clients are identified by their IP address
+type approvalGate struct {
+	mu        sync.Mutex
+	approvals map[string]*approval
+	// request is called once for every new client
+	request func(ip, userAgent string)
+	// reject is called with the reason of every rejected request
+	reject func(reason string)
+	// stop is closed when the server shuts down, to release the requests
+	// still waiting
+	stop <-chan struct{}
+}
+
+// approval is the decision taken for a client
+type approval struct {
+	// decided is closed once the client is accepted or denied
+	decided  chan struct{}
+	accepted bool
+}
+
+func newApprovalGate(request func(ip, userAgent string), stop <-chan struct{}) *approvalGate {
+	return &approvalGate{
+		approvals: make(map[string]*approval),
+		request:   request,
+		reject:    func(string) {},
+		stop:      stop,
+	}
+}
+
+// wrap returns a handler that calls next only for accepted clients. While a
+// client is pending, browsers get a page that waits for the decision, and
+// the requests of other clients are held until it is taken
+func (g *approvalGate) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a := g.get(clientIP(r), r.UserAgent())
+		// The waiting page polls the state of the approval
+		if r.URL.Query().Has("approval") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Write([]byte(a.state()))
+			return
+		}
+		if a.state() == approvalPending {
+			if isBrowser(r) && r.Method == "GET" {
+				w.WriteHeader(http.StatusAccepted)
+				serveTemplate("approval", pages.Approval, w, nil)
+				return
+			}
+			select {
+			case <-a.decided:
+			case <-g.stop:
+				http.Error(w, "the server is shutting down", http.StatusServiceUnavailable)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if !a.accepted {
+			g.reject(rejectedDenied)
+			http.Error(w, "the connection has been denied", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// get returns the approval of the client at ip, asking for it if the client
+// is new
+func (g *approvalGate) get(ip, userAgent string) *approval {
+	g.mu.Lock()
+	a, ok := g.approvals[ip]
+	if !ok {
+		a = &approval{decided: make(chan struct{})}
+		g.approvals[ip] = a
+	}
+	g.mu.Unlock()
+	if !ok {
+		g.request(ip, userAgent)
+	}
+	return a
+}
+
+// decide accepts or denies the client at ip. It reports false if the client
+// is unknown or already decided
+func (g *approvalGate) decide(ip string, accept bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a, ok := g.approvals[ip]
+	if !ok {
+		return false
+	}
+	select {
+	case <-a.decided:
+		return false
+	default:
+	}
+	a.accepted = accept
+	close(a.decided)
+	return true
+}
+
+// state returns one of the approval constants
+func (a *approval) state() string {
+	select {
+	case <-a.decided:
+		if a.accepted {
+			return approvalAccepted
+		}
+		return approvalDenied
+	default:
+		return approvalPending
+	}
+}
+
+// Approve accepts or denies the client at ip, after EventApprovalRequested.
+// It reports false if no approval is pending for ip
+func (s *Server) Approve(ip string, accept bool) bool {
+	if s.approvals == nil {
+		return false
+	}
+	return s.approvals.decide(ip, accept)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestApproval(t *testing.T) {
+	for _, accept := range []bool{true, false} {
+		srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", KeepAlive: true, Approve: true}, nil)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if err := srv.ReceiveTo(t.TempDir()); err != nil {
+			t.Fatalf("ReceiveTo() error = %v", err)
+		}
+		requested := make(chan Event, 10)
+		srv.Subscribe(func(e Event) {
+			if e.Type == EventApprovalRequested {
+				requested <- e
+			}
+		})
+		if err := srv.Start(context.Background()); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		get := func(url, userAgent string) (int, string) {
+			req, _ := http.NewRequest("GET", url, nil)
+			req.Header.Set("User-Agent", userAgent)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", url, err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode, string(body)
+		}
+		// Browsers wait on a page polling for the decision
+		if status, body := get(srv.ReceiveURL, "Mozilla/5.0"); status != http.StatusAccepted || !strings.Contains(body, "Waiting for approval") {
+			t.Errorf("GET %s = %d %q, want the waiting page", srv.ReceiveURL, status, body)
+		}
+		if e := <-requested; e.ClientIP != "127.0.0.1" || e.UserAgent != "Mozilla/5.0" {
+			t.Errorf("approval requested for %+v, want 127.0.0.1 and Mozilla/5.0", e)
+		}
+		if _, state := get(srv.ReceiveURL+"?approval", "Mozilla/5.0"); state != approvalPending {
+			t.Errorf("approval state = %q, want %q", state, approvalPending)
+		}
+		// Other clients are held until the decision
+		held := make(chan int)
+		go func() {
+			status, _ := get(srv.ReceiveURL, "curl")
+			held <- status
+		}()
+		if !srv.Approve("127.0.0.1", accept) {
+			t.Errorf("Approve() = false, want true")
+		}
+		want, wantState := http.StatusOK, approvalAccepted
+		if !accept {
+			want, wantState = http.StatusForbidden, approvalDenied
+		}
+		if status := <-held; status != want {
+			t.Errorf("held request status = %d, want %d", status, want)
+		}
+		if _, state := get(srv.ReceiveURL+"?approval", "Mozilla/5.0"); state != wantState {
+			t.Errorf("approval state = %q, want %q", state, wantState)
+		}
+		if srv.Approve("127.0.0.1", !accept) {
+			t.Errorf("Approve() of a decided client = true, want false")
+		}
+		if len(requested) != 0 {
+			t.Errorf("approval requested %d more times, want once", len(requested))
+		}
+		srv.Close()
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+// certificateValidity is how long a generated certificate is valid for
+const certificateValidity = 30 * 24 * time.Hour
+
+// loadCertificate returns the certificate configured with `tls-cert` and
+// `tls-key`. If none is configured, a self-signed certificate valid for hosts
+// is generated, and cached if `tls-cache` is set
+func loadCertificate(cfg *config.Config, hosts []string) (tls.Certificate, error) {
+	if cfg.TlsCert != "" || cfg.TlsKey != "" {
+		if cfg.TlsCert == "" || cfg.TlsKey == "" {
+			return tls.Certificate{}, errors.New("both tls-cert and tls-key must be set")
+		}
+		return tls.LoadX509KeyPair(cfg.TlsCert, cfg.TlsKey)
+	}
+	if !cfg.TlsCache {
+		return generateCertificate(hosts)
+	}
+	certFile, err := xdg.DataFile("qrcp/tls/cert.pem")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyFile, err := xdg.DataFile("qrcp/tls/key.pem")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && isValidFor(cert, hosts) {
+		return cert, nil
+	}
+	cert, err := generateCertificate(hosts)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
+
+// generateCertificate creates a self-signed ECDSA certificate whose subject
+// alternative names are hosts
+func generateCertificate(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"qrcp"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// isValidFor reports whether cert can be used for all the hosts for at
+// least one more day
+func isValidFor(cert tls.Certificate, hosts []string) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if time.Now().Add(24 * time.Hour).After(leaf.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if err := leaf.VerifyHostname(host); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// fingerprint returns the SHA-256 fingerprint of cert, formatted the way
+// browsers display it
+func fingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
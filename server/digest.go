@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// digest is the SHA-256 checksum and the size of the payload. It is either
+// computed in the background, or set once the payload has been streamed to a
+// client, whichever comes first
+type digest struct {
+	once sync.Once
+	done chan struct{}
+	sum  []byte
+	// size is only read once done is closed
+	size int64
+	err  error
+}
+
+func newDigest() *digest {
+	return &digest{done: make(chan struct{})}
+}
+
+// compute starts computing the checksum of what write writes, unless it is
+// already known or being computed
+func (d *digest) compute(write func(io.Writer) error) {
+	d.once.Do(func() {
+		go func() {
+			defer close(d.done)
+			hash := sha256.New()
+			counter := &countWriter{w: hash}
+			if err := write(counter); err != nil {
+				d.err = err
+				return
+			}
+			d.sum = hash.Sum(nil)
+			d.size = counter.n
+		}()
+	})
+}
+
+// set the checksum and the size, unless they are already known or being
+// computed
+func (d *digest) set(sum []byte, size int64) {
+	d.once.Do(func() {
+		d.sum = sum
+		d.size = size
+		close(d.done)
+	})
+}
+
+// ready returns the checksum if it is known
+func (d *digest) ready() ([]byte, bool) {
+	select {
+	case <-d.done:
+		return d.sum, d.err == nil
+	default:
+		return nil, false
+	}
+}
+
+// wait for the checksum to be known, or for ctx to be done
+func (d *digest) wait(ctx context.Context) ([]byte, error) {
+	select {
+	case <-d.done:
+		return d.sum, d.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readFile returns a function that copies the file at path to a writer
+func readFile(path string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(w, file)
+		return err
+	}
+}
+
+// setDigestHeaders sets the Repr-Digest header of RFC 9530, and the Digest
+// header of RFC 3230 it replaces, which older clients still understand
+func setDigestHeaders(h http.Header, sum []byte) {
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	h.Set("Repr-Digest", "sha-256=:"+encoded+":")
+	h.Set("Digest", "sha-256="+encoded)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// progressInterval is the minimum delay between two EventProgress of the
+// same transfer, so that the listeners are not called for every chunk
+const progressInterval = 100 * time.Millisecond
+
+// EventType identifies what happened during a transfer
+type EventType string
+
+const (
+	// EventTransferStarted is emitted when a client starts uploading, Total
+	// is the size of the whole request
+	EventTransferStarted EventType = "transfer-started"
+	// EventFileStarted is emitted when a file starts being transferred
+	EventFileStarted EventType = "file-started"
+	// EventProgress is emitted while a file is being transferred, Bytes is
+	// the amount transferred so far
+	EventProgress EventType = "progress"
+	// EventFileCompleted is emitted when a file has been transferred, Bytes
+	// is its size
+	EventFileCompleted EventType = "file-completed"
+	// EventTransferCompleted is emitted when all the files uploaded by a
+	// client have been received
+	EventTransferCompleted EventType = "transfer-completed"
+	// EventError is emitted when a transfer fails, ID is set if the failure
+	// is specific to a file
+	EventError EventType = "error"
+	// EventClientConnected is emitted on the first request of a client,
+	// identified by its IP address and user agent
+	EventClientConnected EventType = "client-connected"
+	// EventShutdown is emitted when the server starts shutting down, Reason
+	// tells why
+	EventShutdown EventType = "shutdown"
+	// EventApprovalRequested is emitted on the first request of a client
+	// when clients have to be approved, see Server.Approve
+	EventApprovalRequested EventType = "approval-requested"
+	// EventHook is emitted when a hook has run, Hook is its name and
+	// ExitCode its exit status. Err is set if it failed
+	EventHook EventType = "hook"
+)
+
+// Reasons of EventShutdown
+const (
+	// ShutdownCompleted means that the transfer is completed
+	ShutdownCompleted = "completed"
+	// ShutdownInterrupted means that the context passed to Start is done,
+	// usually because of a signal
+	ShutdownInterrupted = "interrupted"
+	// ShutdownStopped means that Shutdown or Close has been called
+	ShutdownStopped = "stopped"
+	// ShutdownError means that the server or a transfer failed
+	ShutdownError = "error"
+	// ShutdownHookFailed means that a hook failed, and the server is set to
+	// stop in that case
+	ShutdownHookFailed = "hook-failed"
+)
+
+// Event describes something that happened on the server
+type Event struct {
+	Type EventType
+	// ID identifies a file being transferred across its events, as its
+	// path may change once it is stored
+	ID string
+	// File is the path of the file being transferred
+	File  string
+	Bytes int64
+	// Total is the expected number of bytes, -1 if unknown
+	Total int64
+	// SHA256 is the hex-encoded checksum of the file, set on
+	// EventFileCompleted when known
+	SHA256 string
+	// ClientIP and UserAgent identify the client, on EventClientConnected
+	// and EventApprovalRequested. ClientIP is also set on EventFileStarted
+	ClientIP  string
+	UserAgent string
+	// Reason is one of the Shutdown constants, on EventShutdown
+	Reason string
+	// Hook and ExitCode describe the hook that has run, on EventHook
+	Hook     string
+	ExitCode int
+	Err      error
+}
+
+// listeners is the list of functions subscribed to the server events
+type listeners struct {
+	mu  sync.RWMutex
+	fns []func(Event)
+}
+
+// Subscribe registers fn to be called for every event emitted by the server.
+// fn is called from the request handlers, so it should return quickly
+func (s *Server) Subscribe(fn func(Event)) {
+	s.listeners.mu.Lock()
+	defer s.listeners.mu.Unlock()
+	s.listeners.fns = append(s.listeners.fns, fn)
+}
+
+// maxSeenClients is the number of clients remembered by trackClients. Once
+// reached they are all forgotten, so that a kept-alive server doesn't grow
+// the list forever: the clients that come back are reported again
+const maxSeenClients = 1024
+
+// clients remembers who connected to the server, see trackClients
+type clients struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// trackClients emits EventClientConnected before handling the first request
+// of every client
+func (s *Server) trackClients(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, userAgent := clientIP(r), r.UserAgent()
+		key := ip + " " + userAgent
+		s.clients.mu.Lock()
+		seen := s.clients.seen[key]
+		if !seen {
+			if s.clients.seen == nil || len(s.clients.seen) >= maxSeenClients {
+				s.clients.seen = make(map[string]bool)
+			}
+			s.clients.seen[key] = true
+		}
+		s.clients.mu.Unlock()
+		if !seen {
+			s.emit(Event{Type: EventClientConnected, ClientIP: ip, UserAgent: userAgent})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// progressThrottle tells when a transfer is due to report its progress
+type progressThrottle struct {
+	last time.Time
+}
+
+// due reports whether progressInterval has passed since the last time it
+// returned true
+func (p *progressThrottle) due() bool {
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval {
+		return false
+	}
+	p.last = now
+	return true
+}
+
+// emit sends e to all the listeners
+func (s *Server) emit(e Event) {
+	s.listeners.mu.RLock()
+	defer s.listeners.mu.RUnlock()
+	for _, fn := range s.listeners.fns {
+		fn(e)
+	}
+}
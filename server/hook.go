@@ -0,0 +1,79 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// Names of the hooks, as reported in EventHook
+const (
+	HookOnReceive      = "on-receive"
+	HookOnSendComplete = "on-send-complete"
+)
+
+// hooks are the shell commands run after a transfer
+type hooks struct {
+	onReceive      string
+	onSendComplete string
+	// abort stops the server when a hook fails, even if it is kept alive
+	abort bool
+	// mu runs one hook at a time, so that they don't step on each other's
+	// toes when several files are received. wg counts the hooks that are
+	// not done yet, Wait returns after them
+	mu sync.Mutex
+	wg sync.WaitGroup
+}
+
+// hookFile describes the transferred file to a hook
+type hookFile struct {
+	path     string
+	size     int64
+	sha256   string
+	clientIP string
+}
+
+// runHook runs command in the background, with the details of file in the
+// QRCP_FILE, QRCP_SIZE, QRCP_SHA256 and QRCP_CLIENT_IP environment variables.
+// Its output goes to the standard error, which is free even when the
+// standard output is used for the received content
+func (s *Server) runHook(name, command string, file hookFile) {
+	if command == "" {
+		return
+	}
+	s.hooks.wg.Add(1)
+	go func() {
+		defer s.hooks.wg.Done()
+		s.hooks.mu.Lock()
+		defer s.hooks.mu.Unlock()
+		cmd := shellCommand(command)
+		cmd.Env = append(os.Environ(),
+			"QRCP_FILE="+file.path,
+			"QRCP_SIZE="+strconv.FormatInt(file.size, 10),
+			"QRCP_SHA256="+file.sha256,
+			"QRCP_CLIENT_IP="+file.clientIP,
+		)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		exitCode := -1
+		if cmd.ProcessState != nil {
+			exitCode = cmd.ProcessState.ExitCode()
+		}
+		s.emit(Event{Type: EventHook, Hook: name, File: file.path, ExitCode: exitCode, Err: err})
+		if err != nil && s.hooks.abort {
+			s.stop(ShutdownHookFailed)
+		}
+	}()
+}
+
+// shellCommand returns the command that runs command with the shell of the
+// system
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+// upload posts a file named name to the receive URL of srv
+func upload(t *testing.T, srv *Server, name, content string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("files", name)
+	part.Write([]byte(content))
+	form.Close()
+	resp, err := http.Post(srv.ReceiveURL, form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("POST %s error = %v", srv.ReceiveURL, err)
+	}
+	resp.Body.Close()
+}
+
+func TestReceiveHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook uses a POSIX shell")
+	}
+	dir := t.TempDir()
+	env := filepath.Join(t.TempDir(), "env")
+	srv, err := New(&config.Config{
+		Interface: "any",
+		Bind:      "127.0.0.1",
+		OnReceive: `echo "$QRCP_FILE $QRCP_SIZE $QRCP_SHA256 $QRCP_CLIENT_IP" > ` + env,
+	}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(dir); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	var hook Event
+	srv.Subscribe(func(e Event) {
+		if e.Type == EventHook {
+			hook = e
+		}
+	})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	upload(t, srv, "hello.txt", "hello")
+	// Wait returns once the hook is done
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	if hook.Hook != HookOnReceive || hook.ExitCode != 0 || hook.Err != nil {
+		t.Errorf("hook event = %+v, want a successful %s", hook, HookOnReceive)
+	}
+	got, err := os.ReadFile(env)
+	if err != nil {
+		t.Fatalf("the hook did not run: %v", err)
+	}
+	want := filepath.Join(dir, "hello.txt") + " 5 " +
+		"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824 127.0.0.1"
+	if strings.TrimSpace(string(got)) != want {
+		t.Errorf("hook environment = %q, want %q", got, want)
+	}
+}
+
+func TestReceiveHookAbort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook uses a POSIX shell")
+	}
+	srv, err := New(&config.Config{
+		Interface:          "any",
+		Bind:               "127.0.0.1",
+		KeepAlive:          true,
+		OnReceive:          "exit 3",
+		AbortOnHookFailure: true,
+	}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(t.TempDir()); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	var exitCode int
+	var reason string
+	srv.Subscribe(func(e Event) {
+		switch e.Type {
+		case EventHook:
+			exitCode = e.ExitCode
+		case EventShutdown:
+			reason = e.Reason
+		}
+	})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	upload(t, srv, "hello.txt", "hello")
+	// The server is kept alive, only the failing hook stops it
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	if exitCode != 3 {
+		t.Errorf("hook exit code = %d, want 3", exitCode)
+	}
+	if reason != ShutdownHookFailed {
+		t.Errorf("shutdown reason = %q, want %q", reason, ShutdownHookFailed)
+	}
+}
+
+func TestSendHookInterruptedDownload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook uses a POSIX shell")
+	}
+	// Large enough not to fit in the buffers of the connection
+	file := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(file, 64<<20); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(&config.Config{
+		Interface:      "any",
+		Bind:           "127.0.0.1",
+		KeepAlive:      true,
+		OnSendComplete: "true",
+	}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Send(body.Body{Filename: "large.bin", Path: file})
+	events := make(chan Event, 100)
+	srv.Subscribe(func(e Event) {
+		switch e.Type {
+		case EventFileCompleted, EventHook, EventError:
+			events <- e
+		}
+	})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	resp, err := http.Get(srv.SendURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	io.ReadFull(resp.Body, make([]byte, 4096))
+	resp.Body.Close()
+	select {
+	case e := <-events:
+		if e.Type != EventError || e.ID == "" {
+			t.Errorf("event = %+v, want the error of the interrupted download", e)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the interrupted download was not reported")
+	}
+	// Neither the completion nor the hook follow
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
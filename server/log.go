@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// logRequests logs every request once it has been handled
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+		// Aborted responses panic, log them anyway
+		defer func() {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			s.log.Info("request",
+				"ip", clientIP(r),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"bytes", recorder.bytes,
+				"duration", time.Since(start),
+			)
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// responseRecorder records the status and the size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the flushing of the
+// underlying writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logEvent is a listener that logs the events worth keeping a trace of
+func (s *Server) logEvent(e Event) {
+	switch e.Type {
+	case EventClientConnected:
+		s.log.Info("client connected", "ip", e.ClientIP, "user_agent", e.UserAgent)
+	case EventTransferStarted, EventFileStarted, EventTransferCompleted:
+		s.log.Debug(string(e.Type), "file", e.File, "total", e.Total)
+	case EventFileCompleted:
+		// Ranges of a download are not worth a line each
+		level := slog.LevelInfo
+		if e.SHA256 == "" {
+			level = slog.LevelDebug
+		}
+		s.log.Log(context.Background(), level, "file transferred", "file", e.File, "size", e.Total, "sha256", e.SHA256)
+	case EventHook:
+		if e.Err != nil {
+			s.log.Warn("hook failed", "hook", e.Hook, "file", e.File, "exit_code", e.ExitCode, "err", e.Err)
+			return
+		}
+		s.log.Info("hook completed", "hook", e.Hook, "file", e.File, "exit_code", e.ExitCode)
+	case EventShutdown:
+		s.log.Info("shutting down", "reason", e.Reason)
+	case EventError:
+		s.log.Error(e.Err.Error())
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+// syncBuffer is a buffer that the handlers and the test can share
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRequestLog(t *testing.T) {
+	var out syncBuffer
+	log := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, log)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(t.TempDir()); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	resp, err := http.Get(srv.ReceiveURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.ReceiveURL, err)
+	}
+	resp.Body.Close()
+	cancel()
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	var request map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log line %s: %v", line, err)
+		}
+		if record["msg"] == "request" {
+			request = record
+		}
+	}
+	if request == nil {
+		t.Fatalf("no request logged in:\n%s", out.String())
+	}
+	want := map[string]interface{}{
+		"ip":     "127.0.0.1",
+		"method": "GET",
+		"path":   strings.TrimPrefix(srv.ReceiveURL, srv.BaseURL),
+		"status": float64(http.StatusOK),
+	}
+	for key, value := range want {
+		if request[key] != value {
+			t.Errorf("%s = %v, want %v", key, request[key], value)
+		}
+	}
+	if bytes, _ := request["bytes"].(float64); bytes <= 0 {
+		t.Errorf("bytes = %v, want the size of the page", request["bytes"])
+	}
+	if _, ok := request["duration"]; !ok {
+		t.Errorf("duration not logged")
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Reasons of the rejected requests
+const (
+	rejectedSession = "session"
+	rejectedPIN     = "pin"
+	rejectedLockout = "lockout"
+	rejectedBusy    = "busy"
+	rejectedDenied  = "denied"
+	rejectedAddress = "address"
+)
+
+// metrics of the server, in the Prometheus format. They are always
+// collected, and exposed when a metrics address is set
+type metrics struct {
+	registry          *prometheus.Registry
+	sentBytes         prometheus.Counter
+	receivedBytes     prometheus.Counter
+	sentFiles         prometheus.Counter
+	receivedFiles     prometheus.Counter
+	activeConnections prometheus.Gauge
+	rejectedRequests  *prometheus.CounterVec
+	transferDuration  *prometheus.HistogramVec
+}
+
+// newMetrics registers the metrics in a registry of their own, so that
+// several servers can live in the same process
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		sentBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "qrcp_sent_bytes_total",
+			Help: "Bytes sent to clients downloading the payload.",
+		}),
+		receivedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "qrcp_received_bytes_total",
+			Help: "Bytes of the files uploaded by clients.",
+		}),
+		sentFiles: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "qrcp_sent_files_total",
+			Help: "Complete downloads of the payload.",
+		}),
+		receivedFiles: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "qrcp_received_files_total",
+			Help: "Files received and stored.",
+		}),
+		activeConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "qrcp_active_connections",
+			Help: "Connections currently open with clients.",
+		}),
+		rejectedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "qrcp_rejected_requests_total",
+			Help: "Requests rejected, by reason: session (cookie mismatch), pin (wrong PIN), lockout (too many wrong PINs), busy (the content is already being transferred), denied (the client was not approved) or address (the connection came from an address not allowed).",
+		}, []string{"reason"}),
+		transferDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "qrcp_transfer_duration_seconds",
+			Help:    "Duration of the transfers of whole files, by direction: send or receive.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+		}, []string{"direction"}),
+	}
+	m.registry.MustRegister(
+		m.sentBytes,
+		m.receivedBytes,
+		m.sentFiles,
+		m.receivedFiles,
+		m.activeConnections,
+		m.rejectedRequests,
+		m.transferDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// handler serves the metrics
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// connState is the http.Server.ConnState hook that counts the active
+// connections
+func (m *metrics) connState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.activeConnections.Inc()
+	case http.StateClosed, http.StateHijacked:
+		m.activeConnections.Dec()
+	}
+}
+
+// reject counts a request rejected for reason
+func (m *metrics) reject(reason string) {
+	m.rejectedRequests.WithLabelValues(reason).Inc()
+}
+
+// instrumentSend counts the bytes sent by next, and the downloads of the
+// whole payload with their duration
+func (m *metrics) instrumentSend(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+		// Aborted downloads panic, count what they sent anyway
+		defer func() {
+			m.sentBytes.Add(float64(recorder.bytes))
+		}()
+		next(recorder, r)
+		// Ranges and HEAD requests are not a download of the whole payload
+		if r.Method == "HEAD" || (recorder.status != 0 && recorder.status != http.StatusOK) {
+			return
+		}
+		// Neither is a file that the client stopped reading halfway through,
+		// which http.ServeFile doesn't report
+		if length, err := strconv.ParseInt(recorder.Header().Get("Content-Length"), 10, 64); err == nil && recorder.bytes != length {
+			return
+		}
+		m.sentFiles.Inc()
+		m.transferDuration.WithLabelValues("send").Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeReceive records a file received in full, whose upload started at
+// start
+func (m *metrics) observeReceive(start time.Time) {
+	m.receivedFiles.Inc()
+	m.transferDuration.WithLabelValues("receive").Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestMetrics(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(file, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(&config.Config{
+		Interface:   "any",
+		Bind:        "127.0.0.1",
+		KeepAlive:   true,
+		Pin:         "2468",
+		MetricsAddr: "127.0.0.1:0",
+	}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Send(body.Body{Filename: "hello.txt", Path: file})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	get := func(pin, ranges string) {
+		req, _ := http.NewRequest("GET", srv.SendURL, nil)
+		req.Header.Set("X-Qrcp-Pin", pin)
+		if ranges != "" {
+			req.Header.Set("Range", ranges)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", srv.SendURL, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	get("2468", "")
+	get("2468", "bytes=0-4")
+	get("1357", "")
+	resp, err := http.Get("http://" + srv.metricsListener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	exposed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		// The whole file, then a range of 5 bytes
+		"qrcp_sent_bytes_total 16",
+		// Ranges are not a complete download
+		"qrcp_sent_files_total 1",
+		`qrcp_transfer_duration_seconds_count{direction="send"} 1`,
+		`qrcp_rejected_requests_total{reason="pin"} 1`,
+		"qrcp_active_connections",
+	} {
+		if !strings.Contains(string(exposed), want+"\n") && !strings.Contains(string(exposed), want+" ") {
+			t.Errorf("metrics don't contain %q", want)
+		}
+	}
+}
+
+func TestMetricsIncompleteSend(t *testing.T) {
+	m := newMetrics()
+	send := m.instrumentSend(func(w http.ResponseWriter, r *http.Request) {
+		// The client went away after the first half of the file
+		w.Header().Set("Content-Length", "10")
+		io.WriteString(w, "hello")
+	})
+	send(httptest.NewRecorder(), httptest.NewRequest("GET", "/send/path", nil))
+	exposed := httptest.NewRecorder()
+	m.handler().ServeHTTP(exposed, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{"qrcp_sent_bytes_total 5\n", "qrcp_sent_files_total 0\n"} {
+		if !strings.Contains(exposed.Body.String(), want) {
+			t.Errorf("metrics don't contain %q", want)
+		}
+	}
+}
+
+func TestMetricsListenerClosed(t *testing.T) {
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", MetricsAddr: "127.0.0.1:0"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	addr := srv.metricsListener.Addr().String()
+	// The server is closed without being started
+	if err := srv.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("the metrics address is still bound: %v", err)
+	}
+	listener.Close()
+}
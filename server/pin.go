@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/pages"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+const (
+	// maxPINAttempts is the number of wrong PINs a client can enter before
+	// being locked out
+	maxPINAttempts = 5
+	// pinLockout is how long a client is locked out for, it doubles every
+	// time the client is locked out again
+	pinLockout = time.Minute
+)
+
+// pinGate protects handlers with a PIN, entered on a gate page by browsers
+// or sent in the X-Qrcp-Pin header by other clients
+type pinGate struct {
+	pin    string
+	cookie *http.Cookie
+	mu     sync.Mutex
+	// failures holds the failed attempts of each client IP
+	failures map[string]*pinFailures
+	// reject is called with the reason of every rejected attempt
+	reject func(reason string)
+}
+
+type pinFailures struct {
+	count       int
+	lockouts    int
+	lockedUntil time.Time
+}
+
+func newPINGate(pin string, secure bool) (*pinGate, error) {
+	token, err := util.GetSessionID()
+	if err != nil {
+		return nil, err
+	}
+	return &pinGate{
+		pin: pin,
+		cookie: &http.Cookie{
+			Name:     "qrcp-pin",
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteStrictMode,
+		},
+		failures: make(map[string]*pinFailures),
+		reject:   func(string) {},
+	}, nil
+}
+
+// wrap returns a handler that calls next only for clients that entered the
+// PIN, the others get the gate page
+func (g *pinGate) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rcookie, err := r.Cookie(g.cookie.Name); err == nil &&
+			subtle.ConstantTimeCompare([]byte(rcookie.Value), []byte(g.cookie.Value)) == 1 {
+			next(w, r)
+			return
+		}
+		pin := r.Header.Get("X-Qrcp-Pin")
+		fromForm := false
+		if pin == "" && r.Method == "POST" {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+				pin = r.PostFormValue("pin")
+				fromForm = true
+			}
+		}
+		htmlVariables := struct {
+			Route string
+			Error string
+		}{Route: r.URL.Path}
+		if pin == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			serveTemplate("pin", pages.Pin, w, htmlVariables)
+			return
+		}
+		ip := clientIP(r)
+		if wait := g.check(ip, pin); wait > 0 {
+			g.reject(rejectedLockout)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			htmlVariables.Error = fmt.Sprintf("Too many attempts, try again in %s", wait.Round(time.Second))
+			serveTemplate("pin", pages.Pin, w, htmlVariables)
+			return
+		} else if wait < 0 {
+			g.reject(rejectedPIN)
+			w.WriteHeader(http.StatusUnauthorized)
+			htmlVariables.Error = "Wrong PIN"
+			serveTemplate("pin", pages.Pin, w, htmlVariables)
+			return
+		}
+		http.SetCookie(w, g.cookie)
+		if fromForm {
+			// Load the protected page again, this time with the cookie
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// check pin for the client at ip. It returns zero if the pin is correct, a
+// negative value if it is wrong, or how long the client has to wait if it is
+// locked out
+func (g *pinGate) check(ip, pin string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f, ok := g.failures[ip]
+	if !ok {
+		f = &pinFailures{}
+		g.failures[ip] = f
+	}
+	if wait := time.Until(f.lockedUntil); wait > 0 {
+		return wait
+	}
+	if subtle.ConstantTimeCompare([]byte(pin), []byte(g.pin)) == 1 {
+		delete(g.failures, ip)
+		return 0
+	}
+	f.count++
+	if f.count >= maxPINAttempts {
+		f.count = 0
+		f.lockedUntil = time.Now().Add(pinLockout << f.lockouts)
+		f.lockouts++
+	}
+	return -1
+}
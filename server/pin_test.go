@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPINGate(t *testing.T) {
+	gate, err := newPINGate("2468", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := gate.wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	submit := func(pin string) *httptest.ResponseRecorder {
+		form := url.Values{"pin": {pin}}
+		r := httptest.NewRequest("POST", "/send/abcd", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	// No PIN: the gate page is served
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/send/abcd", nil))
+	if w.Code != http.StatusUnauthorized || w.Body.String() == "ok" {
+		t.Errorf("GET without PIN status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	// Right PIN: redirect with the cookie, which unlocks the handler
+	w = submit("2468")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST right PIN status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	r := httptest.NewRequest("GET", "/send/abcd", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Body.String() != "ok" {
+		t.Errorf("GET with cookie body = %q, want %q", w.Body.String(), "ok")
+	}
+	// Header, as used by scripts
+	r = httptest.NewRequest("GET", "/send/abcd", nil)
+	r.Header.Set("X-Qrcp-Pin", "2468")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Body.String() != "ok" {
+		t.Errorf("GET with header body = %q, want %q", w.Body.String(), "ok")
+	}
+	// Wrong PINs: the client is locked out, even with the right PIN
+	for i := 0; i < maxPINAttempts; i++ {
+		if w := submit("0000"); w.Code != http.StatusUnauthorized {
+			t.Errorf("POST wrong PIN status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	}
+	if w := submit("2468"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("POST after lockout status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
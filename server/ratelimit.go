@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits a flow of bytes to rate per second, allowing bursts of
+// one second at most
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait until n bytes can go through. It returns the error of ctx if it is
+// done first
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	// The bytes are taken right away, the tokens can go negative: the next
+	// ones wait for the debt to be paid
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimiter limits the transfer rate of every connection, and of all of
+// them together
+type rateLimiter struct {
+	// global is nil when only the connections are limited
+	global *tokenBucket
+	// perConnection is the rate of every connection, zero if unlimited
+	perConnection int64
+	mu            sync.Mutex
+	// connections holds the bucket of every open connection, by remote
+	// address
+	connections map[string]*tokenBucket
+}
+
+// newRateLimiter returns a limiter of all the connections to rate, and of
+// each of them to perConnection. Zero leaves either unlimited
+func newRateLimiter(rate, perConnection int64) *rateLimiter {
+	l := &rateLimiter{
+		perConnection: perConnection,
+		connections:   make(map[string]*tokenBucket),
+	}
+	if rate > 0 {
+		l.global = newTokenBucket(rate)
+	}
+	return l
+}
+
+// connState is the http.Server.ConnState hook that gives every connection a
+// bucket of its own
+func (l *rateLimiter) connState(conn net.Conn, state http.ConnState) {
+	if l.perConnection == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		l.connections[conn.RemoteAddr().String()] = newTokenBucket(l.perConnection)
+	case http.StateClosed, http.StateHijacked:
+		delete(l.connections, conn.RemoteAddr().String())
+	}
+}
+
+// wait until n bytes can be transferred on the connection of r
+func (l *rateLimiter) wait(r *http.Request, n int) error {
+	if l.perConnection > 0 {
+		l.mu.Lock()
+		conn, ok := l.connections[r.RemoteAddr]
+		if !ok {
+			conn = newTokenBucket(l.perConnection)
+			l.connections[r.RemoteAddr] = conn
+		}
+		l.mu.Unlock()
+		if err := conn.wait(r.Context(), n); err != nil {
+			return err
+		}
+	}
+	if l.global == nil {
+		return nil
+	}
+	return l.global.wait(r.Context(), n)
+}
+
+// writer limits the rate of the response to r
+func (l *rateLimiter) writer(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	return &limitedWriter{ResponseWriter: w, limiter: l, r: r}
+}
+
+// limitBody limits the rate at which the body of r is read
+func (l *rateLimiter) limitBody(r *http.Request) {
+	r.Body = &limitedBody{ReadCloser: r.Body, limiter: l, r: r}
+}
+
+// limitedWriter is a response writer throttled by a rateLimiter
+type limitedWriter struct {
+	http.ResponseWriter
+	limiter *rateLimiter
+	r       *http.Request
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if err := w.limiter.wait(w.r, len(b)); err != nil {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (w *limitedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// limitedBody is a request body throttled by a rateLimiter
+type limitedBody struct {
+	io.ReadCloser
+	limiter *rateLimiter
+	r       *http.Request
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if err := b.limiter.wait(b.r, n); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
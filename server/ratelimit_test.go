@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(1000)
+	start := time.Now()
+	// The first second is a burst, the next half second is waited for
+	for i := 0; i < 3; i++ {
+		if err := bucket.wait(context.Background(), 500); err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("1500 bytes at 1000 B/s took %s, want about 500ms", elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bucket.wait(ctx, 5000); err != context.Canceled {
+		t.Errorf("wait() with a done context error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRateLimiterPerConnection(t *testing.T) {
+	limiter := newRateLimiter(0, 1000)
+	first := httptest.NewRequest("GET", "/", nil)
+	first.RemoteAddr = "192.168.1.13:50000"
+	second := httptest.NewRequest("GET", "/", nil)
+	second.RemoteAddr = "192.168.1.14:50000"
+	start := time.Now()
+	// Every connection has a burst of its own, without a global limit
+	for _, r := range []*http.Request{first, second} {
+		if err := limiter.wait(r, 1000); err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("two connections waited %s, want no wait", elapsed)
+	}
+	if err := limiter.wait(first, 500); err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("1500 bytes on a connection at 1000 B/s took %s, want about 500ms", elapsed)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data.bin")
+	// Twice the rate: the first second is a burst, the second is throttled
+	if err := os.WriteFile(file, make([]byte, 64<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", KeepAlive: true, LimitRate: "32K"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Send(body.Body{Filename: "data.bin", Path: file})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	start := time.Now()
+	resp, err := http.Get(srv.SendURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	n, _ := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if n != 64<<10 {
+		t.Errorf("received %d bytes, want %d", n, 64<<10)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("64K at 32K/s took %s, want about one second", elapsed)
+	}
+	if _, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", LimitRate: "fast"}, nil); err == nil {
+		t.Error("New() with an invalid rate error = nil")
+	}
+	if _, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", LimitRatePerConnection: "0.5"}, nil); err == nil {
+		t.Error("New() with an invalid rate per connection error = nil")
+	}
+}
@@ -2,29 +2,36 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image/jpeg"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/claudiodangelis/qrcp/qr"
 
 	"github.com/claudiodangelis/qrcp/body"
 	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/logger"
 	"github.com/claudiodangelis/qrcp/pages"
 	"github.com/claudiodangelis/qrcp/util"
-	"gopkg.in/cheggaaa/pb.v1"
 )
 
 // Server is the server
@@ -33,11 +40,58 @@ type Server struct {
 	// SendURL is the URL used to send the file
 	SendURL string
 	// ReceiveURL is the URL used to Receive the file
-	ReceiveURL  string
-	instance    *http.Server
-	body        body.Body
-	outputDir   string
-	stopChannel chan bool
+	ReceiveURL string
+	// PIN that clients must enter before the transfer starts, it is empty
+	// when the URLs are not protected
+	PIN string
+	// CertFingerprint is the SHA-256 fingerprint of the TLS certificate, it
+	// is empty when HTTPS is not used
+	CertFingerprint string
+	instance        *http.Server
+	listener        net.Listener
+	// mux is owned by this instance, so that several servers can live in
+	// the same process
+	mux    *http.ServeMux
+	log    *slog.Logger
+	secure bool
+	body   body.Body
+	digest *digest
+	// stored is the payload written to a temporary file, when it is an
+	// archive and a client needs Range requests, see storeArchive
+	stored    body.Body
+	storedErr error
+	storeOnce sync.Once
+	// reading is held while the reader of the body is streamed to a
+	// client, read is set once it has been
+	reading   sync.Mutex
+	read      bool
+	outputDir string
+	// output receives the content of the uploaded file in place of
+	// outputDir, see ReceiveToWriter
+	output      io.Writer
+	stopChannel chan struct{}
+	stopping    atomic.Bool
+	closeOnce   sync.Once
+	listeners   listeners
+	clients     clients
+	status      *statusTracker
+	// approvals is nil unless clients have to be approved
+	approvals *approvalGate
+	// access is nil when anyone may connect
+	access *accessList
+	// limiter is nil when the transfer rate is not limited
+	limiter *rateLimiter
+	// transfers counts the files transferred, to give them an ID
+	transfers atomic.Int64
+	hooks     hooks
+	// webhook is nil when no webhook URL is set
+	webhook *webhook
+	metrics *metrics
+	// metricsServer exposes the metrics on their own address, it is nil
+	// when no metrics address is set
+	metricsServer   *http.Server
+	metricsListener net.Listener
+	staging         staging
 	// expectParallelRequests is set to true when qrcp sends files, in order
 	// to support downloading of parallel chunks
 	expectParallelRequests bool
@@ -61,53 +115,216 @@ func (s *Server) ReceiveTo(dir string) error {
 	return nil
 }
 
+// ReceiveToWriter writes the content of the uploaded file to w, instead of
+// storing it in a directory. Clients can only upload one file: if a form
+// holds more, the first one has already been written to w when the upload
+// fails with the second one
+func (s *Server) ReceiveToWriter(w io.Writer) {
+	s.output = w
+}
+
 // Send adds a handler for sending the file
 func (s *Server) Send(p body.Body) {
 	s.body = p
+	s.digest = newDigest()
+	if p.Path != "" {
+		s.digest.compute(readFile(p.Path))
+	}
 	s.expectParallelRequests = true
 }
 
-// DisplayQR creates a handler for serving the QR code in the browser
+// DisplayQR creates a handler for serving the QR code in the browser, along
+// with the progress of the transfers
 func (s *Server) DisplayQR(url string) {
-	const PATH = "/qr"
+	// The page is opened by the sender, not by the clients, so it doesn't go
+	// through the PIN and approval gates: it is served under a random path
+	// of its own, as it reveals the URL of the transfer
+	token, err := util.GetRandomURLPath(32, "hex")
+	if err != nil {
+		s.log.Error("unable to display the QR code", "err", err)
+		return
+	}
+	path := "/qr/" + token
 	qrImg := qr.RenderImage(url)
-	http.HandleFunc(PATH, func(w http.ResponseWriter, r *http.Request) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		serveTemplate("qr", pages.QR, w, struct {
+			Image  string
+			Events string
+		}{path + "/image", path + "/events"})
+	})
+	s.mux.HandleFunc(path+"/events", s.serveProgress)
+	s.mux.HandleFunc(path+"/image", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/jpeg")
 		if err := jpeg.Encode(w, qrImg, nil); err != nil {
 			panic(err)
 		}
 	})
-	openBrowser(s.BaseURL + PATH)
+	if err := openBrowser(s.BaseURL + path); err != nil {
+		s.log.Error("unable to open the browser", "err", err)
+	}
 }
 
-// Wait for transfer to be completed, it waits forever if kept awlive
-func (s Server) Wait() error {
-	<-s.stopChannel
-	if err := s.instance.Shutdown(context.Background()); err != nil {
-		log.Println(err)
-	}
-	if s.body.DeleteAfterTransfer {
-		if err := s.body.Delete(); err != nil {
-			panic(err)
+// Start serving requests. The server is shut down when ctx is done, when the
+// transfer is completed or when Shutdown is called
+func (s *Server) Start(ctx context.Context) error {
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.stop(ShutdownInterrupted)
+		case <-s.stopChannel:
 		}
+	}()
+	s.log.Info("server started", "addr", s.listener.Addr().String(), "url", s.BaseURL)
+	if s.metricsServer != nil {
+		s.log.Info("serving metrics", "addr", s.metricsListener.Addr().String())
+		go func() {
+			if err := s.metricsServer.Serve(s.metricsListener); err != http.ErrServerClosed {
+				s.emit(Event{Type: EventError, Err: fmt.Errorf("error serving the metrics: %v", err)})
+			}
+		}()
 	}
+	go func() {
+		netListener := tcpKeepAliveListener{
+			TCPListener: s.listener.(*net.TCPListener),
+			access:      s.access,
+			refused: func(addr net.Addr) {
+				s.metrics.reject(rejectedAddress)
+				s.log.Info("connection refused", "addr", addr.String())
+			},
+		}
+		var err error
+		if s.secure {
+			err = s.instance.ServeTLS(netListener, "", "")
+		} else {
+			err = s.instance.Serve(netListener)
+		}
+		if err != http.ErrServerClosed {
+			s.emit(Event{Type: EventError, Err: fmt.Errorf("error starting the server: %v", err)})
+			s.stop(ShutdownError)
+		}
+	}()
 	return nil
 }
 
-// Shutdown the server
-func (s Server) Shutdown() {
-	s.stopChannel <- true
+// Wait for transfer to be completed, it waits forever if kept alive. Hooks
+// that are running are waited for too
+func (s *Server) Wait() error {
+	<-s.stopChannel
+	s.hooks.wg.Wait()
+	return s.Close()
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown the server. It is safe to call it more than once
+func (s *Server) Shutdown() {
+	s.stop(ShutdownStopped)
 }
 
-// New instance of the server
-func New(cfg *config.Config) (*Server, error) {
+// newTransferID returns an ID for a new file transfer
+func (s *Server) newTransferID() string {
+	return strconv.FormatInt(s.transfers.Add(1), 10)
+}
+
+// stop the server, and tell the listeners why. Only the first reason is
+// reported, before Wait returns
+func (s *Server) stop(reason string) {
+	if !s.stopping.CompareAndSwap(false, true) {
+		return
+	}
+	s.emit(Event{Type: EventShutdown, Reason: reason})
+	close(s.stopChannel)
+}
 
-	app := &Server{}
+// Close shuts the server down and releases its resources, deleting the
+// payload if it was created by qrcp
+func (s *Server) Close() error {
+	s.Shutdown()
+	var errs []error
+	s.closeOnce.Do(func() {
+		// Clean up even if the server didn't shut down gracefully, as Close
+		// won't get another chance
+		errs = append(errs, s.instance.Shutdown(context.Background()))
+		// The listener is already closed if the server was started
+		s.listener.Close()
+		// Remove what is left of interrupted uploads
+		s.staging.cleanup()
+		if s.webhook != nil {
+			s.webhook.close()
+		}
+		if s.metricsServer != nil {
+			s.metricsServer.Close()
+			// The listener is only closed by the metrics server if it was
+			// started
+			s.metricsListener.Close()
+		}
+		if s.stored.DeleteAfterTransfer {
+			errs = append(errs, s.stored.Delete())
+		}
+		if s.body.DeleteAfterTransfer {
+			errs = append(errs, s.body.Delete())
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// UsesExternalIP reports whether the external IP of this host has to be
+// retrieved to build the URLs, which happens when binding to `0.0.0.0`
+// without a FQDN
+func UsesExternalIP(cfg *config.Config) bool {
+	if cfg.FQDN != "" {
+		return false
+	}
+	if cfg.Bind != "" {
+		return cfg.Bind == "0.0.0.0"
+	}
+	return cfg.Interface == "any"
+}
+
+// New instance of the server. log receives the logs of the server, nothing
+// is logged if it is nil
+func New(cfg *config.Config, log *slog.Logger) (*Server, error) {
+	if log == nil {
+		log = logger.Discard()
+	}
+	app := &Server{
+		mux:         http.NewServeMux(),
+		log:         log,
+		metrics:     newMetrics(),
+		secure:      cfg.Secure,
+		stopChannel: make(chan struct{}),
+		status:      newStatusTracker(),
+		hooks: hooks{
+			onReceive:      cfg.OnReceive,
+			onSendComplete: cfg.OnSendComplete,
+			abort:          cfg.AbortOnHookFailure,
+		},
+	}
 	// Get the address of the configured interface to bind the server to.
 	// If `bind` configuration parameter has been configured, it takes precedence
 	bind, err := util.GetInterfaceAddress(cfg.Interface)
 	if err != nil {
-		return &Server{}, err
+		return nil, err
+	}
+	if app.access, err = newAccessList(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.LimitRate != "" || cfg.LimitRatePerConnection != "" {
+		var rate, perConnection int64
+		if cfg.LimitRate != "" {
+			if rate, err = util.ParseRate(cfg.LimitRate); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.LimitRatePerConnection != "" {
+			if perConnection, err = util.ParseRate(cfg.LimitRatePerConnection); err != nil {
+				return nil, err
+			}
+		}
+		app.limiter = newRateLimiter(rate, perConnection)
 	}
 	if cfg.Bind != "" {
 		bind = cfg.Bind
@@ -124,16 +341,20 @@ func New(cfg *config.Config) (*Server, error) {
 	// Get a random path to use
 	path := cfg.Path
 	if path == "" {
-		path = util.GetRandomURLPath()
+		path, err = util.GetRandomURLPath(cfg.PathLength, cfg.PathAlphabet)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
 	}
 	// Set the hostname
 	hostname := fmt.Sprintf("%s:%d", bind, port)
 	// Use external IP when using `interface: any`, unless a FQDN is set
-	if bind == "0.0.0.0" && cfg.FQDN == "" {
-		fmt.Println("Retrieving the external IP...")
+	if UsesExternalIP(cfg) {
 		extIP, err := util.GetExternalIP()
 		if err != nil {
-			panic(err)
+			listener.Close()
+			return nil, err
 		}
 		extIPString := extIP.String()
 		fmtstring := "%s:%d"
@@ -159,194 +380,543 @@ func New(cfg *config.Config) (*Server, error) {
 		app.BaseURL, path)
 	// Create a server
 	httpserver := &http.Server{
-		Addr: host,
-		TLSConfig: &tls.Config{
-			MinVersion:               tls.VersionTLS12,
-			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-			PreferServerCipherSuites: true,
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			},
+		Addr:    host,
+		Handler: app.logRequests(app.trackClients(app.mux)),
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			app.metrics.connState(conn, state)
+			if app.limiter != nil {
+				app.limiter.connState(conn, state)
+			}
 		},
-		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
-	}
-	// Create channel to send message to stop server
-	app.stopChannel = make(chan bool)
-	// Create cookie used to verify request is coming from first client to connect
-	cookie := http.Cookie{Name: "qrcp", Value: ""}
-	// Gracefully shutdown when an OS signal is received or when "q" is pressed
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	go func() {
-		<-sig
-		app.stopChannel <- true
-	}()
-	// The handler adds and removes from the sync.WaitGroup
-	// When the group is zero all requests are completed
-	// and the server is shutdown
-	var waitgroup sync.WaitGroup
-	waitgroup.Add(1)
-	var initCookie sync.Once
+		// Errors of the HTTP server, like TLS handshake failures
+		ErrorLog: slog.NewLogLogger(log.Handler(), slog.LevelDebug),
+	}
+	// HTTP/2 is enabled by default with HTTPS. Browsers don't support it on
+	// plain HTTP, so it's turned off there
+	if !cfg.Secure || (cfg.HTTP2 != nil && !*cfg.HTTP2) {
+		httpserver.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	if cfg.Secure {
+		httpserver.TLSConfig, err = tlsConfig(cfg.TlsMinVersion, cfg.TlsCiphers)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		// The certificate must be valid for the host in the URL, and for the
+		// bound address when it's not a wildcard
+		host, _, _ := net.SplitHostPort(hostname)
+		hosts := []string{host}
+		if bind != "0.0.0.0" && bind != host {
+			hosts = append(hosts, strings.Trim(bind, "[]"))
+		}
+		cert, err := loadCertificate(cfg, hosts)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		httpserver.TLSConfig.Certificates = []tls.Certificate{cert}
+		app.CertFingerprint = fingerprint(cert)
+	}
+	// Create the session used to verify requests are coming from the first
+	// client to connect. When all of its requests are completed the server
+	// is shutdown
+	sess, err := newSession()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	// Protect the handlers with a PIN, if set
+	protect := func(handler http.HandlerFunc) http.HandlerFunc {
+		return handler
+	}
+	if cfg.Pin != "" {
+		app.PIN = cfg.Pin
+		if app.PIN == "random" {
+			if app.PIN, err = util.GetRandomPIN(6); err != nil {
+				listener.Close()
+				return nil, err
+			}
+		}
+		gate, err := newPINGate(app.PIN, cfg.Secure)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		gate.reject = app.metrics.reject
+		protect = gate.wrap
+	}
+	// New clients have to be approved before anything else, even entering
+	// the PIN
+	if cfg.Approve {
+		app.approvals = newApprovalGate(func(ip, userAgent string) {
+			app.emit(Event{Type: EventApprovalRequested, ClientIP: ip, UserAgent: userAgent})
+		}, app.stopChannel)
+		app.approvals.reject = app.metrics.reject
+		withPIN := protect
+		protect = func(handler http.HandlerFunc) http.HandlerFunc {
+			return app.approvals.wrap(withPIN(handler))
+		}
+	}
+	app.Subscribe(app.logEvent)
+	app.Subscribe(app.status.update)
+	if cfg.MetricsAddr != "" {
+		app.metricsListener, err = net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("unable to serve the metrics: %v", err)
+		}
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", app.metrics.handler())
+		app.metricsServer = &http.Server{Handler: metricsMux}
+	}
+	// Notify the webhook after the steps that can fail, so that its
+	// goroutine is not started for nothing
+	if cfg.WebhookURL != "" {
+		app.webhook, err = newWebhook(cfg.WebhookURL, cfg.WebhookSecret, func(err error) {
+			app.emit(Event{Type: EventError, Err: err})
+		})
+		if err != nil {
+			listener.Close()
+			if app.metricsListener != nil {
+				app.metricsListener.Close()
+			}
+			return nil, err
+		}
+		app.Subscribe(app.webhook.notify)
+	}
 	// Create handlers
 	// Send handler (sends file to caller)
-	http.HandleFunc("/send/"+path, func(w http.ResponseWriter, r *http.Request) {
-		if !cfg.KeepAlive && strings.HasPrefix(r.Header.Get("User-Agent"), "Mozilla") {
-			if cookie.Value == "" {
-				initCookie.Do(func() {
-					value, err := util.GetSessionID()
-					if err != nil {
-						log.Println("Unable to generate session ID", err)
-						app.stopChannel <- true
-						return
-					}
-					cookie.Value = value
-					http.SetCookie(w, &cookie)
-				})
-			} else {
-				// Check for the expected cookie and value
-				// If it is missing or doesn't match
-				// return a 400 status
-				rcookie, err := r.Cookie(cookie.Name)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return
-				}
-				if rcookie.Value != cookie.Value {
-					http.Error(w, "mismatching cookie", http.StatusBadRequest)
-					return
-				}
-				// If the cookie exits and matches
-				// this is an aadditional request.
-				// Increment the waitgroup
-				waitgroup.Add(1)
+	app.mux.HandleFunc("/send/"+path, protect(app.metrics.instrumentSend(func(w http.ResponseWriter, r *http.Request) {
+		// The end of a browser session is handled when all of its requests
+		// are done, see below
+		inSession := !cfg.KeepAlive && isBrowser(r)
+		if inSession {
+			if err := sess.begin(w, r); err != nil {
+				app.metrics.reject(rejectedSession)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-			// Remove connection from the waitgroup when done
-			defer waitgroup.Done()
+			// Remove request from the session when done
+			defer sess.end()
 		}
 		w.Header().Set("Content-Disposition", "attachment; filename=\""+
 			app.body.Filename+
 			"\"; filename*=UTF-8''"+
 			url.QueryEscape(app.body.Filename))
-		http.ServeFile(w, r, app.body.Path)
-	})
+		file := app.body.Path
+		if file == "" {
+			file = app.body.Filename
+		}
+		id := app.newTransferID()
+		completed := Event{Type: EventFileCompleted, ID: id, File: file, Total: -1}
+		started := Event{Type: EventFileStarted, ID: id, File: file, Total: -1, ClientIP: clientIP(r)}
+		if app.limiter != nil {
+			w = app.limiter.writer(w, r)
+		}
+		progress := &progressWriter{ResponseWriter: w, total: -1, progress: func(written, total int64) {
+			app.emit(Event{Type: EventProgress, ID: id, File: file, Bytes: written, Total: total})
+		}}
+		w = progress
+		if app.body.Reader != nil {
+			// The content can only be read once, refuse other downloads
+			// rather than sending them a part of it
+			if r.Method != "HEAD" {
+				if !app.reading.TryLock() {
+					app.metrics.reject(rejectedBusy)
+					http.Error(w, "the content is being downloaded by another client", http.StatusConflict)
+					return
+				}
+				defer app.reading.Unlock()
+				if app.read {
+					app.metrics.reject(rejectedBusy)
+					http.Error(w, "the content has already been downloaded", http.StatusGone)
+					return
+				}
+				app.read = true
+			}
+			contentType := mime.TypeByExtension(filepath.Ext(app.body.Filename))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			app.emit(started)
+			controller := http.NewResponseController(w)
+			sum, size, err := app.stream(w, r, contentType, func(out io.Writer) error {
+				_, err := io.Copy(flushWriter{out, controller}, app.body.Reader)
+				return err
+			})
+			if err != nil {
+				// The response can't be fixed anymore, abort it so that the
+				// client doesn't take a truncated file for a complete one
+				app.emit(Event{Type: EventError, ID: id, File: file, Err: fmt.Errorf("unable to send the content: %v", err)})
+				panic(http.ErrAbortHandler)
+			}
+			completed.SHA256 = hex.EncodeToString(sum)
+			completed.Bytes, completed.Total = size, size
+			app.sent(r, completed, inSession)
+			return
+		}
+		app.emit(started)
+		if app.body.IsArchive() && r.Header.Get("Range") == "" {
+			sum, size, err := app.stream(w, r, app.body.Archive.ContentType(), app.body.WriteArchive)
+			if err != nil {
+				// The response can't be fixed anymore, abort it so that the
+				// client doesn't take a truncated archive for a complete one
+				app.emit(Event{Type: EventError, ID: id, File: file, Err: fmt.Errorf("unable to send the archive: %v", err)})
+				panic(http.ErrAbortHandler)
+			}
+			completed.SHA256 = hex.EncodeToString(sum)
+			completed.Bytes, completed.Total = size, size
+			app.sent(r, completed, inSession)
+			return
+		}
+		// Ranges of an archive can only be served once it is on disk
+		path := app.body.Path
+		if app.body.IsArchive() {
+			stored, err := app.storeArchive()
+			if err != nil {
+				app.emit(Event{Type: EventError, Err: fmt.Errorf("unable to store the archive: %v", err)})
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			path = stored.Path
+		}
+		// The checksum is computed in the background when the transfer
+		// starts, don't hold the download back waiting for it
+		if sum, ok := app.digest.ready(); ok {
+			setDigestHeaders(w.Header(), sum)
+		}
+		http.ServeFile(w, r, path)
+		// Report what was actually sent: the client may have gone away
+		completed.Bytes, completed.Total = progress.written, progress.length()
+		if r.Method != "HEAD" && completed.Total >= 0 && completed.Bytes != completed.Total {
+			if inSession {
+				sess.abort()
+			}
+			app.emit(Event{Type: EventError, ID: id, File: file, Err: fmt.Errorf("download of %s interrupted after %d bytes out of %d", file, completed.Bytes, completed.Total)})
+			return
+		}
+		// Only report the checksum once per download, not for every chunk,
+		// and when the whole file was sent
+		if r.Header.Get("Range") == "" && completed.Bytes == completed.Total {
+			if sum, err := app.digest.wait(r.Context()); err == nil {
+				completed.SHA256 = hex.EncodeToString(sum)
+			}
+		}
+		app.sent(r, completed, inSession)
+	})))
+	// Checksum handler, in the format of sha256sum, so that the download
+	// can be verified with `sha256sum -c`
+	app.mux.HandleFunc("/send/"+path+".sha256", protect(func(w http.ResponseWriter, r *http.Request) {
+		// An archive created on the fly is only hashed when needed
+		if app.body.IsArchive() {
+			app.digest.compute(app.body.WriteArchive)
+		}
+		sum, err := app.digest.wait(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s  %s\n", hex.EncodeToString(sum), app.body.Filename)
+	}))
+	// Live status of the transfers, for the pages and for dashboards
+	for _, route := range []string{"/send/" + path, "/receive/" + path} {
+		app.mux.HandleFunc(route+"/status", protect(app.serveStatus))
+		app.mux.HandleFunc(route+"/events", protect(app.serveEvents))
+	}
 	// Upload handler (serves the upload page)
-	http.HandleFunc("/receive/"+path, func(w http.ResponseWriter, r *http.Request) {
+	app.mux.HandleFunc("/receive/"+path, protect(func(w http.ResponseWriter, r *http.Request) {
 		htmlVariables := struct {
-			Route string
-			File  string
+			Route  string
+			Single bool
+			Files  []receivedFile
 		}{}
 		htmlVariables.Route = "/receive/" + path
+		htmlVariables.Single = app.output != nil
 		switch r.Method {
 		case "POST":
-			filenames := util.ReadFilenames(app.outputDir)
+			if app.limiter != nil {
+				app.limiter.limitBody(r)
+			}
 			reader, err := r.MultipartReader()
 			if err != nil {
-				fmt.Fprintf(w, "Upload error: %v\n", err)
-				log.Printf("Upload error: %v\n", err)
-				app.stopChannel <- true
+				app.fail(w, http.StatusBadRequest, fmt.Errorf("upload error: %v", err))
 				return
 			}
-			transferredFiles := []string{}
-			progressBar := pb.New64(r.ContentLength)
-			progressBar.ShowCounters = false
+			app.emit(Event{Type: EventTransferStarted, Total: r.ContentLength})
 			for {
 				part, err := reader.NextPart()
 				if err == io.EOF {
 					break
 				}
+				if err != nil {
+					app.fail(w, http.StatusBadRequest, fmt.Errorf("upload error: %v", err))
+					return
+				}
 				// iIf part.FileName() is empty, skip this iteration.
 				if part.FileName() == "" {
 					continue
 				}
-				// Prepare the destination
-				fileName := getFileName(filepath.Base(part.FileName()), filenames)
-				out, err := os.Create(filepath.Join(app.outputDir, fileName))
-				if err != nil {
-					// Output to server
-					fmt.Fprintf(w, "Unable to create the file for writing: %s\n", err)
-					// Output to console
-					log.Printf("Unable to create the file for writing: %s\n", err)
-					// Send signal to server to shutdown
-					app.stopChannel <- true
+				// The parts are streamed as they arrive, so the first file
+				// has already been written to the output when the second
+				// one shows up
+				if app.output != nil && len(htmlVariables.Files) > 0 {
+					app.fail(w, http.StatusConflict, errors.New("upload error: only one file can be received"))
 					return
 				}
-				defer out.Close()
-				// Add name of new file
-				filenames = append(filenames, fileName)
-				// Write the content from POSTed file to the out
-				fmt.Println("Transferring file: ", out.Name())
-				progressBar.Prefix(out.Name())
-				progressBar.Start()
-				buf := make([]byte, 1024)
-				for {
-					// Read a chunk
-					n, err := part.Read(buf)
-					if err != nil && err != io.EOF {
-						// Output to server
-						fmt.Fprintf(w, "Unable to write file to disk: %v", err)
-						// Output to console
-						fmt.Printf("Unable to write file to disk: %v", err)
-						// Send signal to server to shutdown
-						app.stopChannel <- true
-						return
-					}
-					if n == 0 {
-						break
-					}
-					// Write a chunk
-					if _, err := out.Write(buf[:n]); err != nil {
-						// Output to server
-						fmt.Fprintf(w, "Unable to write file to disk: %v", err)
-						// Output to console
-						log.Printf("Unable to write file to disk: %v", err)
-						// Send signal to server to shutdown
-						app.stopChannel <- true
-						return
-					}
-					progressBar.Add(n)
+				file, err := app.receivePart(part, clientIP(r))
+				if err != nil {
+					app.fail(w, http.StatusInternalServerError, err)
+					return
 				}
-				transferredFiles = append(transferredFiles, out.Name())
+				htmlVariables.Files = append(htmlVariables.Files, file)
+				app.runHook(HookOnReceive, app.hooks.onReceive, hookFile{
+					path:     file.Path,
+					size:     file.Size,
+					sha256:   file.SHA256,
+					clientIP: clientIP(r),
+				})
 			}
-			progressBar.FinishPrint("File transfer completed")
-			// Set the value of the variable to the actually transferred files
-			htmlVariables.File = strings.Join(transferredFiles, ", ")
+			app.emit(Event{Type: EventTransferCompleted, Total: r.ContentLength})
 			serveTemplate("done", pages.Done, w, htmlVariables)
 			if !cfg.KeepAlive {
-				app.stopChannel <- true
+				app.stop(ShutdownCompleted)
 			}
 		case "GET":
 			serveTemplate("upload", pages.Upload, w, htmlVariables)
 		}
-	})
-	// Wait for all wg to be done, then send shutdown signal
+	}))
+	// Resumable upload handler, see tus.go
+	tusRoute := "/receive/" + path + "/files/"
+	app.mux.HandleFunc(tusRoute, protect(newTusHandler(app, tusRoute, cfg.KeepAlive).ServeHTTP))
+	// Wait for all the requests of the session to be done, then send
+	// shutdown signal. Nobody may download anything, don't outlive the
+	// server
 	go func() {
-		waitgroup.Wait()
+		select {
+		case <-sess.completed():
+		case <-app.stopChannel:
+			return
+		}
 		if cfg.KeepAlive || !app.expectParallelRequests {
 			return
 		}
-		app.stopChannel <- true
-	}()
-	go func() {
-		netListener := tcpKeepAliveListener{listener.(*net.TCPListener)}
-		if cfg.Secure {
-			if err := httpserver.ServeTLS(netListener, cfg.TlsCert, cfg.TlsKey); err != http.ErrServerClosed {
-				log.Fatalln("error starting the server:", err)
-			}
-		} else {
-			if err := httpserver.Serve(netListener); err != http.ErrServerClosed {
-				log.Fatalln("error starting the server", err)
-			}
+		if !sess.interrupted() {
+			app.sessionCompleted(sess.client)
 		}
+		app.stop(ShutdownCompleted)
 	}()
 	app.instance = httpserver
+	app.listener = listener
 	return app, nil
 }
 
+// sent reports a completed download. Outside of a browser session, a
+// download of the whole content completes the transfer, so the
+// on-send-complete hook is run
+func (s *Server) sent(r *http.Request, completed Event, inSession bool) {
+	s.emit(completed)
+	// The checksum is only set when the whole content was downloaded
+	if inSession || r.Method == "HEAD" || completed.SHA256 == "" {
+		return
+	}
+	s.runHook(HookOnSendComplete, s.hooks.onSendComplete, hookFile{
+		path:     completed.File,
+		size:     completed.Bytes,
+		sha256:   completed.SHA256,
+		clientIP: clientIP(r),
+	})
+}
+
+// sessionCompleted runs the on-send-complete hook when all the requests of
+// the browser session are done
+func (s *Server) sessionCompleted(client string) {
+	if s.hooks.onSendComplete == "" {
+		return
+	}
+	file := hookFile{path: s.body.Path, clientIP: client}
+	if file.path == "" {
+		file.path = s.body.Filename
+	}
+	// The browser may only have downloaded ranges of an archive
+	if s.body.IsArchive() {
+		s.digest.compute(s.body.WriteArchive)
+	}
+	// A reader is hashed while it is streamed, there is nothing to wait for
+	sum, ok := s.digest.ready()
+	if !ok && s.body.Reader == nil {
+		if known, err := s.digest.wait(context.Background()); err == nil {
+			sum, ok = known, true
+		}
+	}
+	if ok {
+		file.sha256 = hex.EncodeToString(sum)
+		file.size = s.digest.size
+	}
+	s.runHook(HookOnSendComplete, s.hooks.onSendComplete, file)
+}
+
+// stream writes the content produced by write straight to the response,
+// for content whose size is not known in advance, like archives created on
+// the fly. Its checksum is sent in the trailers, unless it is already known.
+// It returns the checksum and the size of the content
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, contentType string, write func(io.Writer) error) ([]byte, int64, error) {
+	w.Header().Set("Content-Type", contentType)
+	// Ranges can't be served from a stream, tell clients not to try
+	// parallel downloads
+	w.Header().Set("Accept-Ranges", "none")
+	known, ok := s.digest.ready()
+	if ok {
+		setDigestHeaders(w.Header(), known)
+	} else {
+		w.Header().Set("Trailer", "Repr-Digest, Digest")
+	}
+	if r.Method == "HEAD" {
+		return known, 0, nil
+	}
+	hash := sha256.New()
+	counter := &countWriter{w: w}
+	if err := write(io.MultiWriter(counter, hash)); err != nil {
+		return nil, counter.n, err
+	}
+	sum := hash.Sum(nil)
+	if !ok {
+		setDigestHeaders(w.Header(), sum)
+	}
+	s.digest.set(sum, counter.n)
+	return sum, counter.n, nil
+}
+
+// storeArchive writes the archive to a temporary file the first time it is
+// called, and returns the body for it
+func (s *Server) storeArchive() (body.Body, error) {
+	s.storeOnce.Do(func() {
+		s.stored, s.storedErr = s.body.Store()
+		if s.storedErr == nil {
+			s.digest.compute(readFile(s.stored.Path))
+		}
+	})
+	return s.stored, s.storedErr
+}
+
+// receivedFile is a file written to the output directory
+type receivedFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// receivePart writes part to a hidden temporary file in the output directory,
+// and gives it its final name only once it has been fully read. The checksum
+// of the file is computed while it is written. client is the IP address of
+// the uploader
+func (s *Server) receivePart(part *multipart.Part, client string) (receivedFile, error) {
+	name := filepath.Base(part.FileName())
+	if s.output != nil {
+		return s.receivePartToWriter(part, name, client)
+	}
+	out, err := s.staging.create(s.outputDir, name)
+	if err != nil {
+		return receivedFile{}, fmt.Errorf("unable to create the file for writing: %v", err)
+	}
+	// The final name is only known when the file is complete
+	id := s.newTransferID()
+	s.emit(Event{Type: EventFileStarted, ID: id, File: filepath.Join(s.outputDir, name), Total: -1, ClientIP: client})
+	start := time.Now()
+	hash := sha256.New()
+	written, err := s.copyPart(io.MultiWriter(out, hash), part, id, filepath.Join(s.outputDir, name))
+	if err != nil {
+		out.Close()
+		s.staging.discard(out.Name())
+		return receivedFile{}, fmt.Errorf("unable to write file to disk: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		s.staging.discard(out.Name())
+		return receivedFile{}, fmt.Errorf("unable to write file to disk: %v", err)
+	}
+	if err := checkPartLength(part, name, written); err != nil {
+		s.staging.discard(out.Name())
+		return receivedFile{}, err
+	}
+	path, err := s.staging.commit(out.Name(), s.outputDir, name)
+	if err != nil {
+		s.staging.discard(out.Name())
+		return receivedFile{}, fmt.Errorf("unable to write file to disk: %v", err)
+	}
+	file := receivedFile{Path: path, Size: written, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	s.metrics.observeReceive(start)
+	s.emit(Event{Type: EventFileCompleted, ID: id, File: path, Bytes: written, Total: written, SHA256: file.SHA256})
+	return file, nil
+}
+
+// receivePartToWriter writes part to the output set with ReceiveToWriter
+func (s *Server) receivePartToWriter(part *multipart.Part, name, client string) (receivedFile, error) {
+	id := s.newTransferID()
+	s.emit(Event{Type: EventFileStarted, ID: id, File: name, Total: -1, ClientIP: client})
+	start := time.Now()
+	hash := sha256.New()
+	written, err := s.copyPart(io.MultiWriter(s.output, hash), part, id, name)
+	if err != nil {
+		return receivedFile{}, fmt.Errorf("unable to write to the output: %v", err)
+	}
+	if err := checkPartLength(part, name, written); err != nil {
+		return receivedFile{}, err
+	}
+	file := receivedFile{Path: name, Size: written, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	s.metrics.observeReceive(start)
+	s.emit(Event{Type: EventFileCompleted, ID: id, File: name, Bytes: written, Total: written, SHA256: file.SHA256})
+	return file, nil
+}
+
+// copyPart copies part to out in small chunks, reporting the progress of
+// file. It returns the number of bytes copied
+func (s *Server) copyPart(out io.Writer, part io.Reader, id, file string) (int64, error) {
+	var written int64
+	var progress progressThrottle
+	buf := make([]byte, 32*1024)
+	for {
+		// Read a chunk
+		n, err := part.Read(buf)
+		if n > 0 {
+			// Write a chunk
+			if _, err := out.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			s.metrics.receivedBytes.Add(float64(n))
+			if progress.due() {
+				s.emit(Event{Type: EventProgress, ID: id, File: file, Bytes: written, Total: -1})
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// checkPartLength verifies the size of the file, if the client sent it
+func checkPartLength(part *multipart.Part, name string, written int64) error {
+	if length := part.Header.Get("Content-Length"); length != "" {
+		if size, err := strconv.ParseInt(length, 10, 64); err == nil && size != written {
+			return fmt.Errorf("incomplete upload of %s: received %d bytes out of %d", name, written, size)
+		}
+	}
+	return nil
+}
+
+// fail reports err to the client with the given status, and to the
+// listeners, then shuts the server down
+func (s *Server) fail(w http.ResponseWriter, status int, err error) {
+	http.Error(w, err.Error(), status)
+	s.emit(Event{Type: EventError, Err: err})
+	s.stop(ShutdownError)
+}
+
 // openBrowser navigates to a url using the default system browser
-func openBrowser(url string) {
+func openBrowser(url string) error {
 	var err error
 	switch runtime.GOOS {
 	case "linux":
@@ -358,7 +928,5 @@ func openBrowser(url string) {
 	default:
 		err = fmt.Errorf("failed to open browser on platform: %s", runtime.GOOS)
 	}
-	if err != nil {
-		log.Fatal(err)
-	}
+	return err
 }
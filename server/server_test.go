@@ -0,0 +1,484 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/archive"
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestNewMultipleInstances(t *testing.T) {
+	for round := 0; round < 2; round++ {
+		servers := []*Server{}
+		for i := 0; i < 2; i++ {
+			srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := srv.ReceiveTo(t.TempDir()); err != nil {
+				t.Fatalf("ReceiveTo() error = %v", err)
+			}
+			if err := srv.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			servers = append(servers, srv)
+		}
+		for _, srv := range servers {
+			resp, err := http.Get(srv.ReceiveURL)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", srv.ReceiveURL, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("GET %s status = %d, want %d", srv.ReceiveURL, resp.StatusCode, http.StatusOK)
+			}
+		}
+		for _, srv := range servers {
+			if err := srv.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		}
+	}
+}
+
+func TestNewSelfSignedCertificate(t *testing.T) {
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", Secure: true}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer srv.Close()
+	if err := srv.ReceiveTo(t.TempDir()); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get(srv.ReceiveURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.ReceiveURL, err)
+	}
+	resp.Body.Close()
+	leaf := resp.TLS.PeerCertificates[0]
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("certificate is not valid for the bound address: %v", err)
+	}
+	got := fingerprint(tls.Certificate{Certificate: [][]byte{leaf.Raw}})
+	if got != srv.CertFingerprint {
+		t.Errorf("CertFingerprint = %s, want %s", srv.CertFingerprint, got)
+	}
+}
+
+func TestSendHTTP2ParallelRequests(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(file, make([]byte, 8<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", Secure: true}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Send(body.Body{Filename: "file.bin", Path: file})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	get := func(rangeHeader string) (*http.Response, error) {
+		req, _ := http.NewRequest("GET", srv.SendURL, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		return client.Do(req)
+	}
+	// The first request is still being downloaded while the chunks are
+	// requested, as browsers do
+	first, err := get("")
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	if first.ProtoMajor != 2 {
+		t.Errorf("protocol = %s, want HTTP/2", first.Proto)
+	}
+	var wg sync.WaitGroup
+	for i := 1; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := get(fmt.Sprintf("bytes=%d-%d", i*1024, i*1024+1023))
+			if err != nil {
+				t.Errorf("GET chunk %d error = %v", i, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusPartialContent {
+				t.Errorf("GET chunk %d status = %d, want %d", i, resp.StatusCode, http.StatusPartialContent)
+			}
+		}(i)
+	}
+	wg.Wait()
+	first.Body.Close()
+	// All the requests are done, so the server shuts down by itself
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(file, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", KeepAlive: true}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Send(body.Body{Filename: "hello.txt", Path: file})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	const sum = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	// The sidecar waits for the checksum to be computed
+	resp, err := http.Get(srv.SendURL + ".sha256")
+	if err != nil {
+		t.Fatalf("GET %s.sha256 error = %v", srv.SendURL, err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := sum + "  hello.txt\n"; string(got) != want {
+		t.Errorf("sidecar = %q, want %q", got, want)
+	}
+	resp, err = http.Get(srv.SendURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	resp.Body.Close()
+	if got, want := resp.Header.Get("Repr-Digest"), "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:"; got != want {
+		t.Errorf("Repr-Digest = %q, want %q", got, want)
+	}
+	if got, want := resp.Header.Get("Digest"), "sha-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="; got != want {
+		t.Errorf("Digest = %q, want %q", got, want)
+	}
+}
+
+func TestSendArchive(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := body.FromArgs([]string{dir}, false, archive.Options{})
+	if err != nil {
+		t.Fatalf("FromArgs() error = %v", err)
+	}
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", KeepAlive: true}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Send(payload)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	// The archive is streamed, its checksum comes in the trailers
+	resp, err := http.Get(srv.SendURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	streamed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.ContentLength != -1 {
+		t.Errorf("Content-Length = %d, want none", resp.ContentLength)
+	}
+	if resp.Trailer.Get("Repr-Digest") == "" {
+		t.Errorf("Repr-Digest trailer is missing")
+	}
+	// Ranges are served from a temporary copy of the archive
+	req, _ := http.NewRequest("GET", srv.SendURL, nil)
+	req.Header.Set("Range", "bytes=0-9")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	chunk, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("GET range status = %d, want %d", resp.StatusCode, http.StatusPartialContent)
+	}
+	if !bytes.Equal(chunk, streamed[:10]) {
+		t.Errorf("GET range = %q, want %q", chunk, streamed[:10])
+	}
+	stored := srv.stored.Path
+	if err := srv.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(stored); !os.IsNotExist(err) {
+		t.Errorf("temporary archive %s not deleted", stored)
+	}
+}
+
+func TestSendReader(t *testing.T) {
+	pr, pw := io.Pipe()
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", KeepAlive: true}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Send(body.FromReader(pr, "dump.sql"))
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	resume := make(chan struct{})
+	go func() {
+		pw.Write([]byte("hello "))
+		<-resume
+		pw.Write([]byte("world"))
+		pw.Close()
+	}()
+	resp, err := http.Get(srv.SendURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	// A second client can't download while the content is streamed...
+	second, err := http.Get(srv.SendURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	second.Body.Close()
+	if second.StatusCode != http.StatusConflict {
+		t.Errorf("second GET status = %d, want %d", second.StatusCode, http.StatusConflict)
+	}
+	close(resume)
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(got) != "hello world" {
+		t.Errorf("GET = %q, want %q", got, "hello world")
+	}
+	if got, want := resp.Trailer.Get("Repr-Digest"), "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:"; got != want {
+		t.Errorf("Repr-Digest trailer = %q, want %q", got, want)
+	}
+	// ...nor after
+	third, err := http.Get(srv.SendURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	third.Body.Close()
+	if third.StatusCode != http.StatusGone {
+		t.Errorf("third GET status = %d, want %d", third.StatusCode, http.StatusGone)
+	}
+}
+
+func TestReceiveInterruptedUpload(t *testing.T) {
+	dir := t.TempDir()
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(dir); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	// Send half of the declared body, then drop the connection
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.BaseURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := "--b\r\nContent-Disposition: form-data; name=\"files\"; filename=\"hello.txt\"\r\n\r\nhello"
+	fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: x\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: %d\r\n\r\n%s",
+		strings.TrimPrefix(srv.ReceiveURL, srv.BaseURL), len(body)*2, body)
+	conn.Close()
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		t.Errorf("file left in the output directory: %s", entry.Name())
+	}
+}
+
+func TestReceiveToWriterSingleFile(t *testing.T) {
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var out bytes.Buffer
+	srv.ReceiveToWriter(&out)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	for _, name := range []string{"first.txt", "second.txt"} {
+		part, _ := mw.CreateFormFile("files", name)
+		io.WriteString(part, name)
+	}
+	mw.Close()
+	resp, err := http.Post(srv.ReceiveURL, mw.FormDataContentType(), &form)
+	if err != nil {
+		t.Fatalf("POST %s error = %v", srv.ReceiveURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("POST status = %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	srv.Wait()
+	if out.String() != "first.txt" {
+		t.Errorf("output = %q, want the first file only", out.String())
+	}
+}
+
+func TestCloseReleasesGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if err := srv.ReceiveTo(t.TempDir()); err != nil {
+			t.Fatalf("ReceiveTo() error = %v", err)
+		}
+		// Half of the servers are never started
+		if i%2 == 0 {
+			if err := srv.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+		}
+		if err := srv.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}
+	// The goroutines may take a moment to return
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines after closing the servers, want %d", after, before)
+	}
+}
+
+func TestStartContextCancel(t *testing.T) {
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	cancel()
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	if _, err := http.Get(srv.ReceiveURL); err == nil {
+		t.Errorf("server still reachable after context was cancelled")
+	}
+}
+
+func TestClientAndShutdownEvents(t *testing.T) {
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(t.TempDir()); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	var mu sync.Mutex
+	var events []Event
+	srv.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	// The same client is only reported once
+	for _, userAgent := range []string{"first", "first", "second"} {
+		req, _ := http.NewRequest("GET", srv.ReceiveURL, nil)
+		req.Header.Set("User-Agent", userAgent)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", srv.ReceiveURL, err)
+		}
+		resp.Body.Close()
+	}
+	cancel()
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []Event{
+		{Type: EventClientConnected, ClientIP: "127.0.0.1", UserAgent: "first"},
+		{Type: EventClientConnected, ClientIP: "127.0.0.1", UserAgent: "second"},
+		{Type: EventShutdown, Reason: ShutdownInterrupted},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %+v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestReceiveProgressThrottled(t *testing.T) {
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(t.TempDir()); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	var mu sync.Mutex
+	progress := 0
+	srv.Subscribe(func(e Event) {
+		if e.Type == EventProgress {
+			mu.Lock()
+			progress++
+			mu.Unlock()
+		}
+	})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	start := time.Now()
+	upload(t, srv, "large.bin", strings.Repeat("x", 16<<20))
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// One event per progressInterval at most, plus the first one
+	if max := int(time.Since(start)/progressInterval) + 1; progress > max {
+		t.Errorf("%d progress events in %s, want %d at most", progress, time.Since(start), max)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// session tracks the browser that started a download. Browsers may download
+// in parallel chunks, even over a single HTTP/2 connection, so every request
+// after the first one must carry the session cookie
+type session struct {
+	mu      sync.Mutex
+	cookie  *http.Cookie
+	started bool
+	// client is the IP address of the client that started the session
+	client string
+	// inFlight counts the requests in flight, when it drops to zero the
+	// download is completed and done is closed
+	inFlight int
+	done     chan struct{}
+	// aborted is set when a request of the session didn't send all of its
+	// content
+	aborted bool
+}
+
+func newSession() (*session, error) {
+	value, err := util.GetSessionID()
+	if err != nil {
+		return nil, err
+	}
+	// Account for the first request, so that the session does not end
+	// before it starts
+	return &session{
+		cookie:   &http.Cookie{Name: "qrcp", Value: value},
+		inFlight: 1,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// begin registers a request. The first request starts the session and gets
+// the cookie, the following ones are rejected if they don't carry it
+func (s *session) begin(w http.ResponseWriter, r *http.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		s.started = true
+		s.client = clientIP(r)
+		http.SetCookie(w, s.cookie)
+		return nil
+	}
+	rcookie, err := r.Cookie(s.cookie.Name)
+	if err != nil {
+		return err
+	}
+	if rcookie.Value != s.cookie.Value {
+		return errors.New("mismatching cookie")
+	}
+	s.inFlight++
+	return nil
+}
+
+// end marks a request started with begin as done
+func (s *session) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	if s.inFlight > 0 {
+		return
+	}
+	// Requests may still come in while the server shuts down
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// abort marks the session as not completed, because the client went away
+// in the middle of a request
+func (s *session) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aborted = true
+}
+
+// interrupted reports whether a request of the session was aborted
+func (s *session) interrupted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aborted
+}
+
+// completed returns a channel closed when all the requests of the session
+// are done
+func (s *session) completed() <-chan struct{} {
+	return s.done
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// staging keeps track of the hidden temporary files that received content
+// is written to. They are renamed once complete, and removed if the server
+// shuts down before that
+type staging struct {
+	mu    sync.Mutex
+	files map[string]struct{}
+}
+
+// create a hidden temporary file in dir, for a file that will be named name
+func (s *staging) create(dir, name string) (*os.File, error) {
+	file, err := os.CreateTemp(dir, "."+name+".*.part")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string]struct{})
+	}
+	s.files[file.Name()] = struct{}{}
+	return file, nil
+}
+
+// commit renames the temporary file at tmp to name in dir, or to a variant
+// of it if the name is taken. It returns the final path
+func (s *staging) commit(tmp, dir, name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := filepath.Join(dir, getFileName(name, util.ReadFilenames(dir)))
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	delete(s.files, tmp)
+	return path, nil
+}
+
+// discard removes the temporary file at tmp
+func (s *staging) discard(tmp string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	os.Remove(tmp)
+	delete(s.files, tmp)
+}
+
+// cleanup removes all the temporary files left
+func (s *staging) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tmp := range s.files {
+		os.Remove(tmp)
+	}
+	s.files = nil
+}
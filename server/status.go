@@ -0,0 +1,274 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// statusInterval is the minimum delay between two snapshots streamed at
+	// /events, so that fast transfers don't flood the clients
+	statusInterval = 250 * time.Millisecond
+	// statusHistory is the number of files, and of clients, kept in the
+	// status, so that a kept-alive server doesn't grow it forever. The
+	// oldest complete files and the oldest clients are forgotten first.
+	// Every range of a download counts as a file
+	statusHistory = 100
+	// statusKeepAlive is how often a comment is streamed at /events when
+	// nothing changes, so that proxies don't close the connection
+	statusKeepAlive = 15 * time.Second
+)
+
+// Status is a snapshot of the transfers, served as JSON at /status and
+// streamed at /events
+type Status struct {
+	Files   []FileStatus   `json:"files"`
+	Clients []ClientStatus `json:"clients"`
+}
+
+// FileStatus is the progress of a file transfer
+type FileStatus struct {
+	ID   string `json:"id"`
+	File string `json:"file"`
+	// Bytes is the amount transferred so far
+	Bytes int64 `json:"bytes"`
+	// Total is the expected number of bytes, -1 if unknown
+	Total int64 `json:"total"`
+	// Rate is the average speed of the transfer, in bytes per second
+	Rate float64 `json:"rate"`
+	// ETA is the number of seconds left, nil when it can't be estimated
+	ETA    *float64 `json:"eta"`
+	Done   bool     `json:"done"`
+	SHA256 string   `json:"sha256,omitempty"`
+	// Error tells why the transfer failed, when it did
+	Error string `json:"error,omitempty"`
+}
+
+// ClientStatus describes a client that connected to the server
+type ClientStatus struct {
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// statusTracker keeps the status of the transfers up to date from the
+// server events
+type statusTracker struct {
+	mu      sync.Mutex
+	files   []*fileProgress
+	byID    map[string]*fileProgress
+	clients []ClientStatus
+	// changed is closed, and replaced, every time the status changes
+	changed chan struct{}
+}
+
+// fileProgress is a FileStatus with the times needed to compute its rate
+type fileProgress struct {
+	FileStatus
+	started time.Time
+	ended   time.Time
+}
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{
+		byID:    make(map[string]*fileProgress),
+		changed: make(chan struct{}),
+	}
+}
+
+// update is a listener that applies e to the status
+func (t *statusTracker) update(e Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e.Type {
+	case EventClientConnected:
+		t.clients = append(t.clients, ClientStatus{IP: e.ClientIP, UserAgent: e.UserAgent, ConnectedAt: time.Now()})
+		if excess := len(t.clients) - statusHistory; excess > 0 {
+			t.clients = append(t.clients[:0], t.clients[excess:]...)
+		}
+	case EventError:
+		// Failed transfers end there, and can be forgotten like complete
+		// ones
+		f, ok := t.byID[e.ID]
+		if !ok || f.Done {
+			return
+		}
+		f.Error = e.Err.Error()
+		f.ended = time.Now()
+	case EventFileStarted, EventProgress, EventFileCompleted:
+		if e.ID == "" {
+			return
+		}
+		f, ok := t.byID[e.ID]
+		if !ok {
+			f = &fileProgress{FileStatus: FileStatus{ID: e.ID, Total: -1}, started: time.Now()}
+			t.byID[e.ID] = f
+			t.files = append(t.files, f)
+			t.prune()
+		}
+		// The file may have been renamed when stored
+		f.File = e.File
+		if e.Total >= 0 {
+			f.Total = e.Total
+		}
+		if e.Type == EventFileStarted {
+			break
+		}
+		// A completion that doesn't know how much was sent must not erase
+		// the progress
+		f.Bytes = max(f.Bytes, e.Bytes)
+		if e.Type == EventFileCompleted {
+			f.Done = true
+			f.SHA256 = e.SHA256
+			f.ended = time.Now()
+		}
+	default:
+		return
+	}
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// prune forgets the oldest complete or failed files beyond statusHistory
+func (t *statusTracker) prune() {
+	excess := len(t.files) - statusHistory
+	if excess <= 0 {
+		return
+	}
+	files := t.files[:0]
+	for _, f := range t.files {
+		if excess > 0 && (f.Done || f.Error != "") {
+			delete(t.byID, f.ID)
+			excess--
+			continue
+		}
+		files = append(files, f)
+	}
+	clear(t.files[len(files):])
+	t.files = files
+}
+
+// snapshot returns the current status, and a channel closed when it changes
+func (t *statusTracker) snapshot() (Status, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := Status{
+		Files:   make([]FileStatus, 0, len(t.files)),
+		Clients: append([]ClientStatus{}, t.clients...),
+	}
+	now := time.Now()
+	for _, f := range t.files {
+		file := f.FileStatus
+		end := now
+		if !f.ended.IsZero() {
+			end = f.ended
+		}
+		if elapsed := end.Sub(f.started).Seconds(); elapsed > 0 {
+			file.Rate = float64(f.Bytes) / elapsed
+		}
+		if f.ended.IsZero() && f.Total >= 0 && file.Rate > 0 {
+			eta := float64(f.Total-f.Bytes) / file.Rate
+			file.ETA = &eta
+		}
+		status.Files = append(status.Files, file)
+	}
+	return status, t.changed
+}
+
+// Status returns the progress of the files transferred so far, and the
+// clients that connected
+func (s *Server) Status() Status {
+	status, _ := s.status.snapshot()
+	return status
+}
+
+// serveStatus serves the status as JSON
+func (s *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.Status())
+}
+
+// serveEvents streams the status as server-sent events, every time it
+// changes, until the client goes away or the server shuts down
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	s.streamStatus(w, r, func(status Status) Status {
+		return status
+	})
+}
+
+// serveProgress streams the progress of the files for the QR page. It is
+// not behind the PIN and approval gates, so the paths, checksums, errors
+// and clients are left out
+func (s *Server) serveProgress(w http.ResponseWriter, r *http.Request) {
+	s.streamStatus(w, r, func(status Status) Status {
+		for i := range status.Files {
+			file := &status.Files[i]
+			file.File = filepath.Base(file.File)
+			file.SHA256, file.Error = "", ""
+		}
+		status.Clients = []ClientStatus{}
+		return status
+	})
+}
+
+// streamStatus streams the status passed through view as server-sent
+// events, see serveEvents
+func (s *Server) streamStatus(w http.ResponseWriter, r *http.Request, view func(Status) Status) {
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	keepAlive := time.NewTicker(statusKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		// The status is sent one last time once the server stops
+		stopped := false
+		select {
+		case <-s.stopChannel:
+			stopped = true
+		default:
+		}
+		status, changed := s.status.snapshot()
+		data, err := json.Marshal(view(status))
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+		if stopped {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(statusInterval):
+		case <-s.stopChannel:
+		}
+	wait:
+		for {
+			select {
+			case <-changed:
+				break wait
+			case <-s.stopChannel:
+				break wait
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				if err := controller.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestStatus(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(file, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", KeepAlive: true}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Send(body.Body{Filename: "hello.txt", Path: file})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	events, err := http.Get(srv.SendURL + "/events")
+	if err != nil {
+		t.Fatalf("GET /events error = %v", err)
+	}
+	defer events.Body.Close()
+	if contentType := events.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("/events Content-Type = %q, want text/event-stream", contentType)
+	}
+	stream := bufio.NewScanner(events.Body)
+	// next returns the status carried by the next event of the stream
+	next := func() (Status, bool) {
+		var status Status
+		for stream.Scan() {
+			if data, ok := strings.CutPrefix(stream.Text(), "data: "); ok {
+				if err := json.Unmarshal([]byte(data), &status); err != nil {
+					t.Fatalf("invalid status %q: %v", data, err)
+				}
+				return status, true
+			}
+		}
+		return status, false
+	}
+	if status, _ := next(); len(status.Files) != 0 || len(status.Clients) != 1 {
+		t.Errorf("initial status = %+v, want no files and one client", status)
+	}
+	resp, err := http.Get(srv.SendURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.SendURL, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	for {
+		status, ok := next()
+		if !ok {
+			t.Fatal("the stream ended before the file was sent")
+		}
+		if len(status.Files) == 1 && status.Files[0].Done {
+			break
+		}
+	}
+	resp, err = http.Get(srv.SendURL + "/status")
+	if err != nil {
+		t.Fatalf("GET /status error = %v", err)
+	}
+	var status Status
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("invalid /status: %v", err)
+	}
+	if len(status.Files) != 1 {
+		t.Fatalf("/status files = %+v, want one", status.Files)
+	}
+	if f := status.Files[0]; f.File != file || f.Bytes != 11 || f.Total != 11 || !f.Done || f.ETA != nil || f.SHA256 == "" {
+		t.Errorf("/status file = %+v, want %s sent in full", f, file)
+	}
+	// Close returns once the stream is done
+	if err := srv.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	for {
+		if _, ok := next(); !ok {
+			break
+		}
+	}
+}
+
+func TestStatusHistory(t *testing.T) {
+	tracker := newStatusTracker()
+	// A range whose completion doesn't carry the bytes sent
+	tracker.update(Event{Type: EventFileStarted, ID: "range", Total: -1})
+	tracker.update(Event{Type: EventProgress, ID: "range", Bytes: 5, Total: 5})
+	tracker.update(Event{Type: EventFileCompleted, ID: "range", Total: -1})
+	if status, _ := tracker.snapshot(); status.Files[0].Bytes != 5 || !status.Files[0].Done {
+		t.Errorf("completed range = %+v, want 5 bytes sent", status.Files[0])
+	}
+	tracker.update(Event{Type: EventFileStarted, ID: "running", Total: 10})
+	for i := 0; i < statusHistory+10; i++ {
+		id := fmt.Sprint(i)
+		tracker.update(Event{Type: EventFileStarted, ID: id, Total: 1})
+		tracker.update(Event{Type: EventFileCompleted, ID: id, Bytes: 1, Total: 1})
+	}
+	status, _ := tracker.snapshot()
+	if len(status.Files) != statusHistory {
+		t.Fatalf("%d files in the status, want %d", len(status.Files), statusHistory)
+	}
+	// The files in progress are kept, the oldest complete ones are gone
+	if status.Files[0].ID != "running" || status.Files[1].ID != "11" {
+		t.Errorf("first files = %s, %s, want running, 11", status.Files[0].ID, status.Files[1].ID)
+	}
+	if len(tracker.byID) != statusHistory {
+		t.Errorf("%d files indexed, want %d", len(tracker.byID), statusHistory)
+	}
+	// Failed files are forgotten like complete ones
+	tracker.update(Event{Type: EventError, ID: "running", Err: errors.New("interrupted")})
+	tracker.update(Event{Type: EventFileStarted, ID: "last", Total: 1})
+	if status, _ := tracker.snapshot(); status.Files[0].ID != "11" {
+		t.Errorf("first file = %s, want the failed one to be forgotten", status.Files[0].ID)
+	}
+	for i := 0; i < statusHistory+10; i++ {
+		tracker.update(Event{Type: EventClientConnected, ClientIP: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	if status, _ := tracker.snapshot(); len(status.Clients) != statusHistory || status.Clients[0].IP != "10.0.0.10" {
+		t.Errorf("%d clients in the status, first %+v, want %d from 10.0.0.10", len(status.Clients), status.Clients[0], statusHistory)
+	}
+}
+
+func TestServeProgress(t *testing.T) {
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer srv.Close()
+	srv.emit(Event{Type: EventClientConnected, ClientIP: "192.168.1.13", UserAgent: "curl"})
+	srv.emit(Event{Type: EventFileStarted, ID: "1", File: "/home/user/secret/report.pdf", Total: 10})
+	// The stream ends after one snapshot once the server is stopped
+	srv.Shutdown()
+	w := httptest.NewRecorder()
+	srv.serveProgress(w, httptest.NewRequest("GET", "/qr/token/events", nil))
+	for _, leak := range []string{"/home/user", "192.168.1.13", "curl"} {
+		if strings.Contains(w.Body.String(), leak) {
+			t.Errorf("the progress stream contains %q: %s", leak, w.Body.String())
+		}
+	}
+	if !strings.Contains(w.Body.String(), `"file":"report.pdf"`) {
+		t.Errorf("the progress stream doesn't name the file: %s", w.Body.String())
+	}
+}
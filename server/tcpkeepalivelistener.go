@@ -33,9 +33,14 @@ import (
 	"time"
 )
 
-// tcpKeepAliveListener applies TCP keepalives to the listener
+// tcpKeepAliveListener applies TCP keepalives to the listener, and closes
+// the connections of the peers refused by access
 type tcpKeepAliveListener struct {
 	*net.TCPListener
+	// access is nil when anyone may connect
+	access *accessList
+	// refused is called with the address of every peer refused
+	refused func(addr net.Addr)
 }
 
 // Accept accepts TCP
@@ -44,6 +49,13 @@ func (ln tcpKeepAliveListener) Accept() (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
+	for ln.access != nil && !ln.access.permits(tc.RemoteAddr().(*net.TCPAddr).IP) {
+		ln.refused(tc.RemoteAddr())
+		tc.Close()
+		if tc, err = ln.AcceptTCP(); err != nil {
+			return nil, err
+		}
+	}
 	if err := tc.SetKeepAlive(true); err != nil {
 		panic(err)
 	}
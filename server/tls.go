@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// tlsVersions maps the values accepted by `tls-min-version`
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// intermediateCipherSuites are the TLS 1.2 cipher suites of the
+// "intermediate" profile, they work with RSA, ECDSA and Ed25519 keys.
+// TLS 1.3 suites are not configurable, and all of them are secure
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// tlsConfig returns the TLS configuration for the given minimum version and
+// cipher profile. The "intermediate" profile, the default, accepts TLS 1.2
+// and 1.3 clients, while "modern" only accepts TLS 1.3
+func tlsConfig(minVersion, profile string) (*tls.Config, error) {
+	if minVersion == "" {
+		minVersion = "1.2"
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS version %q, valid values are 1.2 and 1.3", minVersion)
+	}
+	switch profile {
+	case "", "intermediate":
+	case "modern":
+		version = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid TLS cipher profile %q, valid values are intermediate and modern", profile)
+	}
+	return &tls.Config{
+		MinVersion:       version,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		CipherSuites:     intermediateCipherSuites,
+	}, nil
+}
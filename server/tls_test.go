@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSConfig(t *testing.T) {
+	tests := []struct {
+		minVersion string
+		profile    string
+		want       uint16
+		wantErr    bool
+	}{
+		{"", "", tls.VersionTLS12, false},
+		{"1.3", "", tls.VersionTLS13, false},
+		{"1.2", "modern", tls.VersionTLS13, false},
+		{"1.1", "", 0, true},
+		{"", "legacy", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := tlsConfig(tt.minVersion, tt.profile)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("tlsConfig(%q, %q) error = %v, wantErr %v", tt.minVersion, tt.profile, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.MinVersion != tt.want {
+			t.Errorf("tlsConfig(%q, %q) MinVersion = %x, want %x", tt.minVersion, tt.profile, got.MinVersion, tt.want)
+		}
+	}
+}
+
+func TestTLSConfigHandshake(t *testing.T) {
+	ecdsaCert, err := generateCertificate([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := map[string]tls.Certificate{
+		"ecdsa":   ecdsaCert,
+		"ed25519": ed25519Certificate(t),
+	}
+	for name, cert := range certs {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := tlsConfig("1.2", "intermediate")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+			serverConn, clientConn := net.Pipe()
+			defer serverConn.Close()
+			defer clientConn.Close()
+			go tls.Server(serverConn, cfg).Handshake()
+			client := tls.Client(clientConn, &tls.Config{
+				InsecureSkipVerify: true,
+				MaxVersion:         tls.VersionTLS12,
+			})
+			if err := client.Handshake(); err != nil {
+				t.Errorf("TLS 1.2 handshake error = %v", err)
+			}
+		})
+	}
+}
+
+func ed25519Certificate(t *testing.T) tls.Certificate {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,475 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+const (
+	// tusVersion is the version of the tus resumable upload protocol
+	// supported, see https://tus.io/protocols/resumable-upload
+	tusVersion = "1.0.0"
+	// tusEmptyDelay is how long the end of a transfer made of empty files
+	// only waits for other uploads to be created, as they have no data
+	// whose end would tell
+	tusEmptyDelay = 2 * time.Second
+	// tusRetention is how long a kept-alive server remembers an upload
+	// without activity: complete uploads can still be queried by their
+	// client meanwhile, abandoned ones can still be resumed
+	tusRetention = time.Hour
+)
+
+// tusUpload is a file being uploaded with the tus protocol. Its content is
+// stored in a hidden .part file in the output directory until it is complete,
+// or written to the output set with ReceiveToWriter
+type tusUpload struct {
+	// mu guards the fields below, writing is held while a PATCH request
+	// appends to the file. When both tusHandler.mu and mu are needed, they
+	// are locked in this order
+	mu       sync.Mutex
+	writing  sync.Mutex
+	id       string
+	name     string
+	partPath string
+	length   int64
+	offset   int64
+	started  bool
+	done     bool
+	// hash is updated with every chunk written to the file, it is guarded by
+	// writing. sum is set once the upload is done
+	hash hash.Hash
+	sum  []byte
+	// created is when the upload was created, to measure its duration, and
+	// updated when it last received data
+	created time.Time
+	updated time.Time
+	// interrupt stops the PATCH request currently writing, if any
+	interrupt func()
+}
+
+// tusHandler implements the core and creation protocols of tus, plus the
+// termination extension, so that uploads can be resumed after a network
+// failure
+type tusHandler struct {
+	server    *Server
+	route     string
+	keepAlive bool
+	mu        sync.Mutex
+	uploads   map[string]*tusUpload
+	// transferring is set when the uploads of a client are in progress,
+	// and reset when all of them are complete
+	transferring bool
+}
+
+func newTusHandler(server *Server, route string, keepAlive bool) *tusHandler {
+	return &tusHandler{
+		server:    server,
+		route:     route,
+		keepAlive: keepAlive,
+		uploads:   make(map[string]*tusUpload),
+	}
+}
+
+func (h *tusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, h.route)
+	if id == "" {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "OPTIONS, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.create(w, r)
+		return
+	}
+	h.mu.Lock()
+	upload, ok := h.uploads[id]
+	h.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "HEAD":
+		upload.mu.Lock()
+		defer upload.mu.Unlock()
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.length, 10))
+		if upload.done {
+			setDigestHeaders(w.Header(), upload.sum)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		h.patch(w, r, upload)
+	case "DELETE":
+		h.terminate(w, id, upload)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// create a new upload, and the .part file that holds its content
+func (h *tusHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	name := filepath.Base(metadata["filename"])
+	if name == "." || name == string(filepath.Separator) {
+		name = "upload"
+	}
+	id, err := util.GetRandomURLPath(32, "hex")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	upload := &tusUpload{id: id, name: name, length: length, hash: sha256.New(), created: time.Now()}
+	upload.updated = upload.created
+	h.prune()
+	h.mu.Lock()
+	// The content of a single file can be written to the output of
+	// ReceiveToWriter
+	if h.server.output != nil && len(h.uploads) > 0 {
+		h.mu.Unlock()
+		h.server.metrics.reject(rejectedBusy)
+		http.Error(w, "only one file can be received", http.StatusConflict)
+		return
+	}
+	h.uploads[id] = upload
+	h.mu.Unlock()
+	if h.server.output == nil {
+		part, err := h.server.staging.create(h.server.outputDir, name)
+		if err != nil {
+			h.mu.Lock()
+			delete(h.uploads, id)
+			h.mu.Unlock()
+			h.server.emit(Event{Type: EventError, Err: fmt.Errorf("unable to create the file for writing: %v", err)})
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		part.Close()
+		upload.partPath = part.Name()
+	}
+	// Empty files are complete as soon as they are created, clients don't
+	// send any data for them
+	if length == 0 {
+		upload.mu.Lock()
+		upload.started = true
+		upload.mu.Unlock()
+		h.start()
+		file := name
+		if h.server.output == nil {
+			file = filepath.Join(h.server.outputDir, name)
+		}
+		h.server.emit(Event{Type: EventFileStarted, ID: id, File: file, Total: 0, ClientIP: clientIP(r)})
+		if err := h.finalize(upload, clientIP(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setDigestHeaders(w.Header(), upload.sum)
+		// The client may still be creating its other uploads
+		time.AfterFunc(tusEmptyDelay, h.checkCompleted)
+	}
+	w.Header().Set("Location", h.route+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// patch appends the request body to the upload
+func (h *tusHandler) patch(w http.ResponseWriter, r *http.Request, upload *tusUpload) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/offset+octet-stream" {
+		http.Error(w, "invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	// A client that lost its connection may resume while the server is still
+	// waiting on the stale request, interrupt it before taking over
+	upload.mu.Lock()
+	if upload.interrupt != nil {
+		upload.interrupt()
+	}
+	upload.mu.Unlock()
+	upload.writing.Lock()
+	defer upload.writing.Unlock()
+	controller := http.NewResponseController(w)
+	upload.mu.Lock()
+	upload.interrupt = func() {
+		controller.SetReadDeadline(time.Now())
+	}
+	if upload.done || offset != upload.offset {
+		upload.interrupt = nil
+		current := upload.offset
+		upload.mu.Unlock()
+		w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		http.Error(w, "mismatching Upload-Offset", http.StatusConflict)
+		return
+	}
+	started := upload.started
+	upload.started = true
+	upload.mu.Unlock()
+	file := upload.name
+	if h.server.output == nil {
+		file = filepath.Join(h.server.outputDir, upload.name)
+	}
+	if !started {
+		h.start()
+		h.server.emit(Event{Type: EventFileStarted, ID: upload.id, File: file, Total: upload.length, ClientIP: clientIP(r)})
+	}
+	defer func() {
+		upload.mu.Lock()
+		upload.interrupt = nil
+		upload.mu.Unlock()
+	}()
+	out, err := h.open(upload)
+	if err != nil {
+		h.server.emit(Event{Type: EventError, ID: upload.id, File: file, Err: fmt.Errorf("unable to write file to disk: %v", err)})
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer out.Close()
+	// Write what is received, even if the connection drops halfway: the
+	// offset tells the client where to resume from
+	if h.server.limiter != nil {
+		h.server.limiter.limitBody(r)
+	}
+	body := io.LimitReader(r.Body, upload.length-offset)
+	buf := make([]byte, 32*1024)
+	var progress progressThrottle
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				h.server.emit(Event{Type: EventError, ID: upload.id, File: file, Err: fmt.Errorf("unable to write file to disk: %v", err)})
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			upload.hash.Write(buf[:n])
+			h.server.metrics.receivedBytes.Add(float64(n))
+			upload.mu.Lock()
+			upload.offset += int64(n)
+			upload.updated = time.Now()
+			current := upload.offset
+			upload.mu.Unlock()
+			if progress.due() {
+				h.server.emit(Event{Type: EventProgress, ID: upload.id, File: file, Bytes: current, Total: upload.length})
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			// The client is gone, or another request took over
+			return
+		}
+	}
+	out.Close()
+	upload.mu.Lock()
+	complete := upload.offset == upload.length
+	current := upload.offset
+	upload.mu.Unlock()
+	if complete {
+		if err := h.finalize(upload, clientIP(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setDigestHeaders(w.Header(), upload.sum)
+		h.checkCompleted()
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate removes an upload and its .part file
+func (h *tusHandler) terminate(w http.ResponseWriter, id string, upload *tusUpload) {
+	h.mu.Lock()
+	delete(h.uploads, id)
+	h.mu.Unlock()
+	upload.mu.Lock()
+	if upload.interrupt != nil {
+		upload.interrupt()
+	}
+	upload.mu.Unlock()
+	upload.writing.Lock()
+	defer upload.writing.Unlock()
+	if !upload.done && upload.partPath != "" {
+		h.server.staging.discard(upload.partPath)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	h.checkCompleted()
+}
+
+// open the destination of the content of upload, for appending
+func (h *tusHandler) open(upload *tusUpload) (io.WriteCloser, error) {
+	if h.server.output != nil {
+		return nopWriteCloser{h.server.output}, nil
+	}
+	return os.OpenFile(upload.partPath, os.O_WRONLY|os.O_APPEND, 0)
+}
+
+// start marks the beginning of a transfer, if none is in progress
+func (h *tusHandler) start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.transferring {
+		return
+	}
+	h.transferring = true
+	// Clients create all the uploads before sending any data, so the size of
+	// the transfer is known
+	var total int64
+	for _, upload := range h.uploads {
+		upload.mu.Lock()
+		if !upload.done {
+			total += upload.length
+		}
+		upload.mu.Unlock()
+	}
+	h.server.emit(Event{Type: EventTransferStarted, Total: total})
+}
+
+// finalize renames the .part file of a complete upload, and runs the
+// on-receive hook for it. It must not run while a chunk is being written.
+// The caller checks whether the transfer is completed
+func (h *tusHandler) finalize(upload *tusUpload, client string) error {
+	path := upload.name
+	if h.server.output == nil {
+		var err error
+		path, err = h.server.staging.commit(upload.partPath, h.server.outputDir, upload.name)
+		if err != nil {
+			err = fmt.Errorf("unable to write file to disk: %v", err)
+			h.server.emit(Event{Type: EventError, Err: err})
+			return err
+		}
+	}
+	sum := upload.hash.Sum(nil)
+	upload.mu.Lock()
+	upload.done = true
+	upload.sum = sum
+	upload.mu.Unlock()
+	h.server.metrics.observeReceive(upload.created)
+	h.server.emit(Event{Type: EventFileCompleted, ID: upload.id, File: path, Bytes: upload.length, Total: upload.length, SHA256: hex.EncodeToString(sum)})
+	h.server.runHook(HookOnReceive, h.server.hooks.onReceive, hookFile{
+		path:     path,
+		size:     upload.length,
+		sha256:   hex.EncodeToString(sum),
+		clientIP: client,
+	})
+	return nil
+}
+
+// checkCompleted signals the end of the transfer when every upload has
+// been finalized. tus has no way to tell that a client is done, so without
+// keep-alive the server stops as soon as the uploads created so far are
+// complete: clients must create all of them before sending any data, as the
+// upload page does. Empty files are complete once created, the check waits
+// for tusEmptyDelay after them
+func (h *tusHandler) checkCompleted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.transferring || h.server.stopping.Load() {
+		return
+	}
+	for _, upload := range h.uploads {
+		upload.mu.Lock()
+		done := upload.done
+		upload.mu.Unlock()
+		if !done {
+			return
+		}
+	}
+	h.transferring = false
+	h.server.emit(Event{Type: EventTransferCompleted})
+	if !h.keepAlive {
+		h.server.stop(ShutdownCompleted)
+	}
+}
+
+// prune forgets the uploads without activity for tusRetention, removing the
+// .part files of the abandoned ones. Only kept-alive servers live long enough
+// to need it, and the output of ReceiveToWriter takes a single upload anyway
+func (h *tusHandler) prune() {
+	if !h.keepAlive || h.server.output != nil {
+		return
+	}
+	var abandoned []*tusUpload
+	h.mu.Lock()
+	for id, upload := range h.uploads {
+		upload.mu.Lock()
+		stale := upload.interrupt == nil && time.Since(upload.updated) > tusRetention
+		done := upload.done
+		upload.mu.Unlock()
+		if !stale {
+			continue
+		}
+		delete(h.uploads, id)
+		if !done {
+			abandoned = append(abandoned, upload)
+		}
+	}
+	h.mu.Unlock()
+	for _, upload := range abandoned {
+		// Wait for a PATCH request that found the upload before it was
+		// forgotten
+		upload.writing.Lock()
+		h.server.staging.discard(upload.partPath)
+		upload.writing.Unlock()
+	}
+	// The transfer may only have been waiting for the abandoned uploads
+	if len(abandoned) > 0 {
+		h.checkCompleted()
+	}
+}
+
+// parseTusMetadata parses the Upload-Metadata header, a comma-separated list
+// of keys followed by their base64-encoded value
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		value := ""
+		if len(fields) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiodangelis/qrcp/config"
+)
+
+func TestTusUpload(t *testing.T) {
+	dir := t.TempDir()
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(dir); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	completed := make(chan struct{})
+	srv.Subscribe(func(e Event) {
+		if e.Type == EventTransferCompleted {
+			close(completed)
+		}
+	})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	// The upload page is served
+	resp, err := http.Get(srv.ReceiveURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", srv.ReceiveURL, err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "/files/") {
+		t.Errorf("upload page does not use the tus endpoint")
+	}
+	do := func(method, url string, headers map[string]string, body string) *http.Response {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Tus-Resumable", tusVersion)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", method, url, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	endpoint := srv.ReceiveURL + "/files/"
+	resp = do("POST", endpoint, map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("hello.txt")),
+	}, "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	upload := srv.BaseURL + resp.Header.Get("Location")
+	patch := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	if resp := do("PATCH", upload, patch, "hello "); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PATCH status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	// The part file holds the content received so far
+	parts, _ := filepath.Glob(filepath.Join(dir, ".hello.txt.*.part"))
+	if len(parts) != 1 {
+		t.Fatalf("found %d part files, want 1", len(parts))
+	}
+	// Resume from the offset known by the server
+	resp = do("HEAD", upload, nil, "")
+	if got := resp.Header.Get("Upload-Offset"); got != "6" {
+		t.Fatalf("HEAD Upload-Offset = %s, want 6", got)
+	}
+	patch["Upload-Offset"] = "0"
+	if resp := do("PATCH", upload, patch, "world"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("PATCH with wrong offset status = %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	patch["Upload-Offset"] = "6"
+	resp = do("PATCH", upload, patch, "world")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PATCH status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	// The checksum of the complete file is returned with the last chunk
+	if got, want := resp.Header.Get("Repr-Digest"), "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:"; got != want {
+		t.Errorf("Repr-Digest = %q, want %q", got, want)
+	}
+	<-completed
+	got, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+	if err != nil || string(got) != "hello world" {
+		t.Errorf("received file = %q, %v, want %q", got, err, "hello world")
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dir, ".*.part")); len(parts) != 0 {
+		t.Errorf("part files left after completion: %v", parts)
+	}
+}
+
+func TestTusUploadToWriter(t *testing.T) {
+	var out bytes.Buffer
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.ReceiveToWriter(&out)
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	do := func(method, url string, headers map[string]string, body string) *http.Response {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Tus-Resumable", tusVersion)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", method, url, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	endpoint := srv.ReceiveURL + "/files/"
+	create := map[string]string{"Upload-Length": "11"}
+	resp := do("POST", endpoint, create, "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	upload := srv.BaseURL + resp.Header.Get("Location")
+	// Only one file can be written to the output
+	if resp := do("POST", endpoint, create, ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second POST status = %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	patch := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	if resp := do("PATCH", upload, patch, "hello "); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PATCH status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	patch["Upload-Offset"] = "6"
+	if resp := do("PATCH", upload, patch, "world"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PATCH status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	// The transfer is complete, so the server shuts down by itself
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	if out.String() != "hello world" {
+		t.Errorf("output = %q, want %q", out.String(), "hello world")
+	}
+}
+
+func TestTusEmptyUploads(t *testing.T) {
+	dir := t.TempDir()
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := srv.ReceiveTo(dir); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	completed := make(chan struct{}, 2)
+	srv.Subscribe(func(e Event) {
+		if e.Type == EventTransferCompleted {
+			completed <- struct{}{}
+		}
+	})
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Close()
+	for _, name := range []string{"first.txt", "second.txt"} {
+		req, _ := http.NewRequest("POST", srv.ReceiveURL+"/files/", nil)
+		req.Header.Set("Tus-Resumable", tusVersion)
+		req.Header.Set("Upload-Length", "0")
+		req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(name)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("POST status = %d, want %d", resp.StatusCode, http.StatusCreated)
+		}
+	}
+	// No data follows, the server stops once no other upload is created
+	stopped := make(chan error)
+	go func() { stopped <- srv.Wait() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Wait() error = %v", err)
+		}
+	case <-time.After(tusEmptyDelay + 5*time.Second):
+		t.Fatal("the server didn't stop after receiving empty files")
+	}
+	if len(completed) != 1 {
+		t.Errorf("transfer completed %d times, want once", len(completed))
+	}
+	for _, name := range []string{"first.txt", "second.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("empty file not received: %v", err)
+		}
+	}
+}
+
+func TestTusPrune(t *testing.T) {
+	dir := t.TempDir()
+	srv, err := New(&config.Config{Interface: "any", Bind: "127.0.0.1", KeepAlive: true}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer srv.Close()
+	if err := srv.ReceiveTo(dir); err != nil {
+		t.Fatalf("ReceiveTo() error = %v", err)
+	}
+	h := newTusHandler(srv, "/files/", true)
+	create := func(name string, length string) {
+		req := httptest.NewRequest("POST", "/files/", nil)
+		req.Header.Set("Tus-Resumable", tusVersion)
+		req.Header.Set("Upload-Length", length)
+		req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(name)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST status = %d, want %d", w.Code, http.StatusCreated)
+		}
+	}
+	// A complete upload and an abandoned one
+	create("empty.txt", "0")
+	create("abandoned.txt", "10")
+	for _, upload := range h.uploads {
+		upload.updated = upload.updated.Add(-tusRetention - time.Second)
+	}
+	create("new.txt", "10")
+	if len(h.uploads) != 1 {
+		t.Errorf("%d uploads left, want the new one only", len(h.uploads))
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dir, ".abandoned.txt.*.part")); len(parts) != 0 {
+		t.Errorf("part files of the abandoned upload left: %v", parts)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "empty.txt")); err != nil {
+		t.Errorf("complete upload removed: %v", err)
+	}
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	got := parseTusMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential")
+	if got["filename"] != "world_domination_plan.pdf" {
+		t.Errorf("filename = %q, want %q", got["filename"], "world_domination_plan.pdf")
+	}
+	if _, ok := got["is_confidential"]; !ok {
+		t.Errorf("key without value is missing")
+	}
+}
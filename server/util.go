@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"net"
+	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -36,3 +39,93 @@ func getFileName(newFilename string, fileNamesInTargetDir []string) string {
 	}
 	return newFilename
 }
+
+// clientIP returns the IP address of the client that sent r
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// flushWriter flushes the response after every write, so that the client
+// gets the content as soon as it is available
+type flushWriter struct {
+	w          io.Writer
+	controller *http.ResponseController
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.controller.Flush()
+}
+
+// nopWriteCloser is a writer whose Close method does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// countWriter counts the bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// progressWriter reports how much of the response has been written
+type progressWriter struct {
+	http.ResponseWriter
+	written int64
+	// total is the Content-Length of the response, -1 if unknown
+	total    int64
+	progress func(written, total int64)
+	throttle progressThrottle
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if p.written == 0 {
+		if length, err := strconv.ParseInt(p.Header().Get("Content-Length"), 10, 64); err == nil {
+			p.total = length
+		}
+	}
+	n, err := p.ResponseWriter.Write(b)
+	if n > 0 {
+		p.written += int64(n)
+		if p.throttle.due() {
+			p.progress(p.written, p.total)
+		}
+	}
+	return n, err
+}
+
+// length returns the Content-Length of the response, -1 if unknown. Unlike
+// total, it is known even if nothing was written
+func (p *progressWriter) length() int64 {
+	if length, err := strconv.ParseInt(p.Header().Get("Content-Length"), 10, 64); err == nil {
+		return length
+	}
+	return -1
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (p *progressWriter) Unwrap() http.ResponseWriter {
+	return p.ResponseWriter
+}
+
+// isBrowser reports whether r comes from a web browser
+func isBrowser(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("User-Agent"), "Mozilla")
+}
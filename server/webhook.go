@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	// webhookAttempts is the number of times a payload is delivered before
+	// giving up
+	webhookAttempts = 5
+	// webhookGrace is how long Close waits for the pending deliveries
+	webhookGrace = 5 * time.Second
+)
+
+// errWebhook wraps the delivery errors reported as EventError, so that they
+// are not delivered in turn
+var errWebhook = errors.New("webhook delivery failed")
+
+// webhookPayload is the JSON body POSTed to the webhook
+type webhookPayload struct {
+	Event     EventType `json:"event"`
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	File      string    `json:"file,omitempty"`
+	Size      *int64    `json:"size,omitempty"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// webhook POSTs the connect, complete and error events to a URL. Events are
+// queued and delivered in the background, so that transfers are never held
+// back by a slow or unreachable endpoint
+type webhook struct {
+	url    string
+	secret string
+	host   string
+	client *http.Client
+	queue  chan webhookPayload
+	// backoff is the delay before the first retry, doubled after every
+	// failed attempt
+	backoff time.Duration
+	// failed is called when a payload could not be delivered
+	failed  func(error)
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// newWebhook starts delivering events to rawURL, signed with secret if set
+func newWebhook(rawURL, secret string, failed func(error)) (*webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webhook URL %s: the scheme must be http or https", rawURL)
+	}
+	host, _ := os.Hostname()
+	w := &webhook{
+		url:     rawURL,
+		secret:  secret,
+		host:    host,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan webhookPayload, 100),
+		backoff: time.Second,
+		failed:  failed,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// notify is a listener that queues the events the webhook is interested in
+func (w *webhook) notify(e Event) {
+	payload := webhookPayload{Event: e.Type, Time: time.Now(), Host: w.host, File: e.File}
+	switch e.Type {
+	case EventClientConnected:
+		payload.ClientIP = e.ClientIP
+		payload.UserAgent = e.UserAgent
+	case EventFileCompleted:
+		// Ranges of a download are reported without a checksum, only
+		// notify whole files
+		if e.SHA256 == "" {
+			return
+		}
+		// The size is unknown when a file is sent
+		if e.Total >= 0 {
+			payload.Size = &e.Total
+		}
+		payload.SHA256 = e.SHA256
+	case EventError:
+		if errors.Is(e.Err, errWebhook) {
+			return
+		}
+		payload.Error = e.Err.Error()
+	default:
+		return
+	}
+	select {
+	case w.queue <- payload:
+	default:
+		// The endpoint can't keep up, the event is dropped rather than
+		// blocking the transfer
+	}
+}
+
+// run delivers the queued payloads until the webhook is closed, then
+// delivers the ones left
+func (w *webhook) run() {
+	defer close(w.done)
+	for {
+		select {
+		case payload := <-w.queue:
+			w.deliver(payload)
+		case <-w.closing:
+			for {
+				select {
+				case payload := <-w.queue:
+					w.deliver(payload)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver POSTs payload, retrying with exponential backoff on network
+// errors and server errors
+func (w *webhook) deliver(payload webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		w.failed(fmt.Errorf("%w: %v", errWebhook, err))
+		return
+	}
+	delay := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			w.failed(fmt.Errorf("%w: %v", errWebhook, err))
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends body once. It reports whether a failed delivery is worth
+// retrying
+func (w *webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "qrcp")
+	if w.secret != "" {
+		req.Header.Set("X-Qrcp-Signature", "sha256="+sign(body, w.secret))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s responded with %s", w.url, resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// close waits for the pending deliveries, for webhookGrace at most
+func (w *webhook) close() {
+	close(w.closing)
+	select {
+	case <-w.done:
+	case <-time.After(webhookGrace):
+	}
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of body
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	var payloads []webhookPayload
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		// Fail the first attempt, the payload must be delivered again
+		if attempts == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get("X-Qrcp-Signature"), "sha256="+sign(body, "s3cret"); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		var payload webhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid payload %s: %v", body, err)
+		}
+		payloads = append(payloads, payload)
+	}))
+	defer endpoint.Close()
+	var failures []error
+	hook, err := newWebhook(endpoint.URL, "s3cret", func(err error) {
+		failures = append(failures, err)
+	})
+	if err != nil {
+		t.Fatalf("newWebhook() error = %v", err)
+	}
+	hook.backoff = time.Millisecond
+	hook.notify(Event{Type: EventClientConnected, ClientIP: "192.168.1.2", UserAgent: "phone"})
+	hook.notify(Event{Type: EventProgress, File: "photo.jpg", Bytes: 1024, Total: -1})
+	hook.notify(Event{Type: EventFileCompleted, File: "photo.jpg", Bytes: 5, Total: 5, SHA256: "abc"})
+	hook.notify(Event{Type: EventError, Err: errWebhook})
+	hook.close()
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	if len(failures) > 0 {
+		t.Errorf("failures = %v", failures)
+	}
+	if len(payloads) != 2 {
+		t.Fatalf("payloads = %+v, want 2", payloads)
+	}
+	if p := payloads[0]; p.Event != EventClientConnected || p.ClientIP != "192.168.1.2" || p.UserAgent != "phone" {
+		t.Errorf("connect payload = %+v", p)
+	}
+	if p := payloads[1]; p.Event != EventFileCompleted || p.File != "photo.jpg" || p.Size == nil || *p.Size != 5 || p.SHA256 != "abc" {
+		t.Errorf("complete payload = %+v", p)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer endpoint.Close()
+	var failures []error
+	hook, err := newWebhook(endpoint.URL, "", func(err error) {
+		failures = append(failures, err)
+	})
+	if err != nil {
+		t.Fatalf("newWebhook() error = %v", err)
+	}
+	hook.notify(Event{Type: EventError, Err: errors.New("disk full")})
+	hook.close()
+	mu.Lock()
+	defer mu.Unlock()
+	// Client errors are not retried
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+	if len(failures) != 1 || !errors.Is(failures[0], errWebhook) {
+		t.Errorf("failures = %v, want one delivery error", failures)
+	}
+}
+
+func TestNewWebhookInvalidURL(t *testing.T) {
+	if _, err := newWebhook("ftp://example.com", "", func(error) {}); err == nil {
+		t.Errorf("newWebhook() accepted a URL that is not HTTP")
+	}
+}
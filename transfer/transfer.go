@@ -0,0 +1,175 @@
+// Package transfer sends and receives files with qrcp from other Go programs.
+// Unlike the qrcp command, it never prompts for input nor prints anything:
+// everything that happens during a transfer is reported as an Event.
+package transfer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/claudiodangelis/qrcp/archive"
+	"github.com/claudiodangelis/qrcp/body"
+	"github.com/claudiodangelis/qrcp/config"
+	"github.com/claudiodangelis/qrcp/server"
+	"github.com/claudiodangelis/qrcp/util"
+)
+
+// Event is emitted while a transfer is running
+type Event = server.Event
+
+// Options of a transfer. The zero value is a valid configuration, which
+// binds the server to the first suitable network interface on a random port
+type Options struct {
+	// Interface is the name of the network interface to use, `any` binds
+	// the server to 0.0.0.0. The first suitable interface is used if empty
+	Interface string
+	// Bind overrides the address of Interface
+	Bind string
+	// Port to listen on, 0 means random
+	Port int
+	// Path of the URL, random if empty
+	Path string
+	// FQDN is used in the URL in place of the IP address
+	FQDN string
+	// KeepAlive keeps the server alive after the first transfer, until the
+	// context is done
+	KeepAlive bool
+	// Secure serves the content over HTTPS, using TLSCert and TLSKey
+	Secure  bool
+	TLSCert string
+	TLSKey  string
+	// Zip the content before sending it, even if it is a single file
+	Zip bool
+	// Archive sets the format and the compression of the archive, used for
+	// directories and multiple files
+	Archive archive.Options
+	// Logger receives the logs of the server, nothing is logged if nil
+	Logger *slog.Logger
+}
+
+// Send serves the files at paths and returns the URL to download them from.
+// The events channel is closed when the transfer is completed, or when ctx
+// is done. The channel must be drained, otherwise the transfer blocks
+func Send(ctx context.Context, paths []string, opts Options) (string, <-chan Event, error) {
+	if len(paths) == 0 {
+		return "", nil, errors.New("no paths to send")
+	}
+	cfg, err := opts.config()
+	if err != nil {
+		return "", nil, err
+	}
+	payload, err := body.FromArgs(paths, opts.Zip, opts.Archive)
+	if err != nil {
+		return "", nil, err
+	}
+	srv, err := server.New(cfg, opts.Logger)
+	if err != nil {
+		if payload.DeleteAfterTransfer {
+			payload.Delete()
+		}
+		return "", nil, err
+	}
+	srv.Send(payload)
+	events, err := start(ctx, srv)
+	if err != nil {
+		return "", nil, err
+	}
+	return srv.SendURL, events, nil
+}
+
+// Receive serves an upload page and stores the received files in dir. It
+// returns the URL of the upload page and a channel of events, with the same
+// semantics of Send
+func Receive(ctx context.Context, dir string, opts Options) (string, <-chan Event, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return "", nil, err
+	}
+	srv, err := server.New(cfg, opts.Logger)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := srv.ReceiveTo(dir); err != nil {
+		srv.Close()
+		return "", nil, err
+	}
+	events, err := start(ctx, srv)
+	if err != nil {
+		return "", nil, err
+	}
+	return srv.ReceiveURL, events, nil
+}
+
+// start the server and forward its events to the returned channel, which is
+// closed once the server is shut down
+func start(ctx context.Context, srv *server.Server) (<-chan Event, error) {
+	events := make(chan Event, 64)
+	var mu sync.Mutex
+	closed := false
+	srv.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		// Progress is reported often, skip it if the receiver is slow
+		if e.Type == server.EventProgress {
+			select {
+			case events <- e:
+			default:
+			}
+			return
+		}
+		events <- e
+	})
+	if err := srv.Start(ctx); err != nil {
+		srv.Close()
+		return nil, err
+	}
+	go func() {
+		err := srv.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			events <- Event{Type: server.EventError, Err: err}
+		}
+		closed = true
+		close(events)
+	}()
+	return events, nil
+}
+
+// config converts the options to a configuration, without prompting
+func (opts Options) config() (*config.Config, error) {
+	cfg := &config.Config{
+		Interface: opts.Interface,
+		Bind:      opts.Bind,
+		Port:      opts.Port,
+		Path:      opts.Path,
+		FQDN:      opts.FQDN,
+		KeepAlive: opts.KeepAlive,
+		Secure:    opts.Secure,
+		TlsCert:   opts.TLSCert,
+		TlsKey:    opts.TLSKey,
+	}
+	if cfg.Interface != "" {
+		return cfg, nil
+	}
+	interfaces, err := util.Interfaces(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(interfaces) == 0 {
+		return nil, errors.New("no interfaces found")
+	}
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cfg.Interface = names[0]
+	return cfg, nil
+}
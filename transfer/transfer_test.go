@@ -0,0 +1,79 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/claudiodangelis/qrcp/server"
+)
+
+var loopback = Options{Interface: "any", Bind: "127.0.0.1"}
+
+func TestSend(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	url, events, err := Send(ctx, []string{file}, loopback)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", url, err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(got) != "hello" {
+		t.Errorf("GET %s = %q, want %q", url, got, "hello")
+	}
+	// Non-browser clients do not complete the transfer, stop it explicitly
+	cancel()
+	for e := range events {
+		if e.Type == server.EventError {
+			t.Errorf("unexpected error event: %v", e.Err)
+		}
+	}
+}
+
+func TestReceive(t *testing.T) {
+	dir := t.TempDir()
+	url, events, err := Receive(context.Background(), dir, loopback)
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("files", "hello.txt")
+	fw.Write([]byte("hello"))
+	mw.Close()
+	resp, err := http.Post(url, mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatalf("POST %s error = %v", url, err)
+	}
+	resp.Body.Close()
+	completed := false
+	for e := range events {
+		if e.Type == server.EventError {
+			t.Errorf("unexpected error event: %v", e.Err)
+		}
+		if e.Type == server.EventFileCompleted && e.Bytes == 5 {
+			completed = true
+		}
+	}
+	if !completed {
+		t.Errorf("no %s event received", server.EventFileCompleted)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+	if err != nil || string(got) != "hello" {
+		t.Errorf("received file = %q, %v, want %q", got, err, "hello")
+	}
+}
@@ -1,6 +1,8 @@
 package util
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"regexp"
 
@@ -31,6 +33,44 @@ func Interfaces(listAll bool) (map[string]string, error) {
 	return names, nil
 }
 
+// InterfaceNetworks returns the networks the interface is connected to,
+// from its addresses and netmasks. The interface "any" is connected to the
+// networks of all the interfaces that are up
+func InterfaceNetworks(ifaceString string) ([]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var networks []*net.IPNet
+	found := false
+	for _, iface := range ifaces {
+		if ifaceString == "any" {
+			if iface.Flags&net.FlagUp == 0 {
+				continue
+			}
+		} else if iface.Name != ifaceString {
+			continue
+		}
+		found = true
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				networks = append(networks, &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask})
+			}
+		}
+	}
+	if !found {
+		return nil, errors.New("unable to find interface")
+	}
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("no network found for interface %s", ifaceString)
+	}
+	return networks, nil
+}
+
 // GetExternalIP of this host
 func GetExternalIP() (net.IP, error) {
 	consensus := externalip.DefaultConsensus(nil, nil)
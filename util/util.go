@@ -4,7 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net"
 	"os"
 	"os/user"
@@ -12,9 +15,6 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"time"
-
-	"github.com/jhoonb/archivex"
 )
 
 // Expand tilde in paths
@@ -32,61 +32,72 @@ func Expand(input string) string {
 	return input
 }
 
-// ZipFiles and return the resulting zip's filename
-func ZipFiles(files []string) (string, error) {
-	zip := new(archivex.ZipFile)
-	tmpfile, err := os.CreateTemp("", "qrcp")
-	if err != nil {
-		return "", err
-	}
-	tmpfile.Close()
-	if err := os.Rename(tmpfile.Name(), tmpfile.Name()+".zip"); err != nil {
-		return "", err
-	}
-	tmpfile, err = os.OpenFile(tmpfile.Name()+".zip", os.O_RDWR, 0644)
-	if err != nil {
-		return "", err
-	}
-	if err := zip.CreateWriter(tmpfile.Name(), tmpfile); err != nil {
-		return "", err
-	}
+// URLPathAlphabets are the named alphabets accepted by GetRandomURLPath
+var URLPathAlphabets = map[string]string{
+	"alphanumeric": "abcdefghijklmnopqrstuvwxyz0123456789",
+	"numeric":      "0123456789",
+	"hex":          "0123456789abcdef",
+}
 
-	for _, filename := range files {
-		fileinfo, err := os.Stat(filename)
-		if err != nil {
-			return "", err
+// GetRandomURLPath returns a random string of length symbols drawn from
+// alphabet using crypto/rand. The alphabet is one of URLPathAlphabets,
+// "words" to join random words with dashes, or a custom set of characters.
+// Zero values select 6 alphanumeric characters, or 3 words
+func GetRandomURLPath(length int, alphabet string) (string, error) {
+	if length < 0 {
+		return "", errors.New("the length of the URL path must be positive")
+	}
+	if alphabet == "words" {
+		if length == 0 {
+			length = 3
 		}
-		if fileinfo.IsDir() {
-			if err := zip.AddAll(filename, true); err != nil {
-				return "", err
-			}
-		} else {
-			file, err := os.Open(filename)
+		chosen := make([]string, length)
+		for i := range chosen {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(words))))
 			if err != nil {
 				return "", err
 			}
-			defer file.Close()
-			if err := zip.Add(filename, file, fileinfo); err != nil {
-				return "", err
-			}
+			chosen[i] = words[n.Int64()]
 		}
+		return strings.Join(chosen, "-"), nil
 	}
-
-	if err := zip.Writer.Close(); err != nil {
-		return "", err
+	if length == 0 {
+		length = 6
 	}
-	if err := tmpfile.Close(); err != nil {
-		return "", err
+	if alphabet == "" {
+		alphabet = "alphanumeric"
 	}
-
-	return zip.Name, nil
+	if named, ok := URLPathAlphabets[alphabet]; ok {
+		alphabet = named
+	}
+	symbols := []rune{}
+	for _, r := range alphabet {
+		if !isUnreservedURLChar(r) {
+			return "", fmt.Errorf("character %q can't be used in a URL path", r)
+		}
+		if !strings.ContainsRune(string(symbols), r) {
+			symbols = append(symbols, r)
+		}
+	}
+	if len(symbols) < 2 {
+		return "", errors.New("the URL path alphabet needs at least two characters")
+	}
+	path := make([]rune, length)
+	for i := range path {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(symbols))))
+		if err != nil {
+			return "", err
+		}
+		path[i] = symbols[n.Int64()]
+	}
+	return string(path), nil
 }
 
-// GetRandomURLPath returns a random string of 4 alphanumeric characters
-func GetRandomURLPath() string {
-	timeNum := time.Now().UTC().UnixNano()
-	alphaString := strconv.FormatInt(timeNum, 36)
-	return alphaString[len(alphaString)-4:]
+// isUnreservedURLChar reports whether r can be used in a URL path without
+// being escaped
+func isUnreservedURLChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+		(r >= '0' && r <= '9') || strings.ContainsRune("-._~", r)
 }
 
 // GetSessionID returns a base64 encoded string of 40 random characters
@@ -98,6 +109,56 @@ func GetSessionID() (string, error) {
 	return base64.StdEncoding.EncodeToString(randbytes), nil
 }
 
+// GetRandomPIN returns a string of random decimal digits
+func GetRandomPIN(digits int) (string, error) {
+	randbytes := make([]byte, digits)
+	if _, err := io.ReadFull(rand.Reader, randbytes); err != nil {
+		return "", err
+	}
+	pin := make([]byte, digits)
+	for i, b := range randbytes {
+		// 250 is the largest multiple of 10 that fits in a byte, larger
+		// values are replaced to avoid biasing the result
+		for b >= 250 {
+			var extra [1]byte
+			if _, err := io.ReadFull(rand.Reader, extra[:]); err != nil {
+				return "", err
+			}
+			b = extra[0]
+		}
+		pin[i] = '0' + b%10
+	}
+	return string(pin), nil
+}
+
+// ParseRate parses a rate in bytes per second, like 500K or 5M. The K, M and
+// G suffixes are multiples of 1024
+func ParseRate(rate string) (int64, error) {
+	value := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(rate)), "B")
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	bytes := n * float64(multiplier)
+	// Fractions of a byte can't be transferred, the negation also rejects
+	// NaN
+	if err != nil || !(bytes >= 1 && bytes < math.MaxInt64) {
+		return 0, fmt.Errorf("invalid rate %q, expected at least one byte per second like 500K or 5M", rate)
+	}
+	return int64(bytes), nil
+}
+
 // GetInterfaceAddress returns the address of the network interface to
 // bind the server to. If the interface is "any", it will return 0.0.0.0.
 // If no interface is found with that name, an error is returned
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGetRandomURLPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		length   int
+		alphabet string
+		want     *regexp.Regexp
+		wantErr  bool
+	}{
+		{"default", 0, "", regexp.MustCompile(`^[a-z0-9]{6}$`), false},
+		{"numeric", 8, "numeric", regexp.MustCompile(`^[0-9]{8}$`), false},
+		{"hex", 12, "hex", regexp.MustCompile(`^[0-9a-f]{12}$`), false},
+		{"custom", 5, "AB", regexp.MustCompile(`^[AB]{5}$`), false},
+		{"words", 0, "words", regexp.MustCompile(`^[a-z]+-[a-z]+-[a-z]+$`), false},
+		{"reserved character", 4, "ab/", nil, true},
+		{"single character", 4, "aaa", nil, true},
+		{"negative length", -1, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetRandomURLPath(tt.length, tt.alphabet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetRandomURLPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !tt.want.MatchString(got) {
+				t.Errorf("GetRandomURLPath() = %q, want match for %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRandomURLPathWords(t *testing.T) {
+	got, err := GetRandomURLPath(4, "words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, word := range strings.Split(got, "-") {
+		found := false
+		for _, w := range words {
+			if w == word {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("GetRandomURLPath() word %q is not in the word list", word)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate    string
+		want    int64
+		wantErr bool
+	}{
+		{"1000", 1000, false},
+		{"500K", 500 << 10, false},
+		{"5M", 5 << 20, false},
+		{"1.5m", 3 << 19, false},
+		{"2GB", 2 << 30, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"0", 0, true},
+		{"0.5", 0, true},
+		{"0.5K", 512, false},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"-5M", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.rate)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRate(%q) error = %v, wantErr %v", tt.rate, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package util
+
+// words used to build URL paths that are easy to type, see GetRandomURLPath
+var words = []string{
+	"acid", "acorn", "actor", "adobe", "after", "agent", "alarm", "album",
+	"alert", "alley", "amber", "angle", "ankle", "apple", "april", "apron",
+	"arena", "argue", "arrow", "atlas", "attic", "audio", "autumn", "award",
+	"bacon", "badge", "bagel", "baker", "bamboo", "banjo", "barn", "basil",
+	"basin", "beach", "bean", "bear", "bench", "berry", "bison", "blade",
+	"blank", "blaze", "blend", "block", "bloom", "blue", "board", "boat",
+	"bonus", "book", "boost", "boxer", "brain", "brave", "bread", "brick",
+	"bridge", "brook", "brush", "bucket", "buddy", "bugle", "cabin", "cable",
+	"cactus", "camel", "candy", "canoe", "canyon", "cargo", "carpet", "castle",
+	"cedar", "chalk", "charm", "cherry", "chess", "chief", "chili", "cider",
+	"cinema", "circle", "citrus", "civic", "clay", "cliff", "clock", "cloud",
+	"clover", "coast", "cobra", "cocoa", "comet", "coral", "cotton", "couch",
+	"crane", "crater", "creek", "crisp", "crown", "cube", "cupid", "curry",
+	"daisy", "dance", "delta", "denim", "depot", "desert", "diary", "dingo",
+	"disco", "dolphin", "donut", "dragon", "dream", "drift", "drum", "eagle",
+	"echo", "elbow", "elder", "ember", "emerald", "engine", "equal", "falcon",
+	"fancy", "feather", "fence", "ferry", "fiber", "field", "fig", "flame",
+	"flask", "fleet", "flint", "flute", "focus", "forest", "fossil", "fox",
+	"frost", "fruit", "galaxy", "garden", "garlic", "gecko", "gem", "giant",
+	"ginger", "glacier", "glove", "goat", "gold", "gorilla", "grape", "gravel",
+	"green", "guitar", "habit", "hammer", "harbor", "hazel", "heron", "hiker",
+	"honey", "hotel", "humor", "igloo", "index", "iris", "island", "ivory",
+	"jacket", "jaguar", "jazz", "jelly", "jewel", "jockey", "juice", "jungle",
+	"kayak", "kettle", "kiwi", "koala", "ladder", "lagoon", "lake", "lamp",
+	"lemon", "lilac", "lime", "linen", "lion", "lizard", "llama", "lobster",
+	"locket", "lotus", "lunar", "magnet", "mango", "maple", "marble", "meadow",
+	"melon", "metal", "mint", "mirror", "mocha", "moon", "moose", "mosaic",
+	"motor", "music", "nectar", "noble", "nova", "oasis", "ocean", "olive",
+	"onion", "opal", "orange", "orbit", "otter", "owl", "oyster", "paddle",
+	"panda", "paper", "parrot", "pasta", "peach", "pearl", "pebble", "pepper",
+	"piano", "pilot", "pine", "pixel", "plum", "polar", "pony", "poppy",
+	"prism", "pumpkin", "puzzle", "quartz", "quill", "rabbit", "radar", "radio",
+	"raven", "reef", "ribbon", "river", "robin", "rocket", "rose", "ruby",
+	"saddle", "salmon", "sand", "satin", "scarf", "seven", "shadow", "shell",
+	"silver", "sketch", "sky", "slate", "snow", "solar", "spark", "spice",
+	"spruce", "squid", "star", "stone", "storm", "sugar", "summit", "sunset",
+	"swan", "tango", "tiger", "timber", "toast", "tulip", "tundra", "turtle",
+	"velvet", "violet", "walnut", "whale", "willow", "window", "winter",
+	"yacht", "zebra",
+}